@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// budgetReport summarizes how a -max-tokens budget was spent
+type budgetReport struct {
+	budget     int
+	used       int
+	chosen     int
+	candidates int
+	truncated  int
+}
+
+// entryTokens estimates the tokens an entry takes up in the output,
+// including its header
+func entryTokens(entry *FileEntry, opts *Options) int {
+	return headerTokens(entry, opts) + opts.tokenizer.count(entry.content)
+}
+
+// headerTokens estimates the tokens of everything written for an entry
+// besides its content, by having the -format writer render it without
+// content. Header templates, comment headers and separators are thus
+// counted as written. Citation numbers are assigned after the budget, so
+// the widest one is assumed.
+func headerTokens(entry *FileEntry, opts *Options) int {
+	bare := *entry
+	bare.content = nil
+	if opts.citeIndex {
+		bare.citeIndex = 999999
+	}
+
+	// A failing header template is reported when the entry is written
+	var buf bytes.Buffer
+	_ = newFormatWriter(&buf, runInfo{}, opts).writeEntry(&bare)
+	return opts.tokenizer.count(buf.Bytes())
+}
+
+// fitTokenBudget selects entries whose estimated tokens fit within budget and
+// returns them in their original order. By default entries are taken
+// greedily in order, skipping any that no longer fit. With fit set, the
+// cheapest entries are taken first, which includes as many files as the
+// budget allows, and a second pass then trades them for larger ones where
+// that keeps the count and uses more of the budget.
+func fitTokenBudget(entries []*FileEntry, budget int, fit bool, opts *Options) (kept, dropped []*FileEntry, report budgetReport) {
+	costs := make(map[*FileEntry]int, len(entries))
+	for _, entry := range entries {
+		costs[entry] = entryTokens(entry, opts)
+	}
+
+	order := entries
+	if fit {
+		order = slices.Clone(entries)
+		slices.SortStableFunc(order, func(a, b *FileEntry) int {
+			return costs[a] - costs[b]
+		})
+	}
+
+	chosen := make(map[*FileEntry]bool)
+	used := 0
+	for _, entry := range order {
+		if used+costs[entry] <= budget {
+			chosen[entry] = true
+			used += costs[entry]
+		}
+	}
+
+	// With the count maximized, swap chosen entries for larger ones that still
+	// fit so more of the budget is used; the largest gain that fits wins
+	if fit {
+		for i := len(order) - 1; i >= 0; i-- {
+			candidate := order[i]
+			if chosen[candidate] {
+				continue
+			}
+			for _, small := range order {
+				if !chosen[small] || costs[small] >= costs[candidate] {
+					continue
+				}
+				if used-costs[small]+costs[candidate] <= budget {
+					delete(chosen, small)
+					chosen[candidate] = true
+					used += costs[candidate] - costs[small]
+					break
+				}
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if chosen[entry] {
+			kept = append(kept, entry)
+		} else {
+			dropped = append(dropped, entry)
+		}
+	}
+	return kept, dropped, budgetReport{budget: budget, used: used, chosen: len(kept), candidates: len(entries)}
+}
+
+// truncationMarker ends the content of a file cut by -over-budget truncate
+func truncationMarker(shown, total int) string {
+	return fmt.Sprintf("### [truncated: %d of %d lines shown to fit -max-tokens]\n", shown, total)
+}
+
+// truncateToBudget keeps every entry but cuts the content of the largest
+// ones at a line boundary so the total fits within budget. All cut entries
+// share one content limit, the highest that fits, so smaller files stay
+// whole. Only when the headers alone exceed the budget are entries dropped,
+// from the end of the output.
+func truncateToBudget(entries []*FileEntry, budget int, opts *Options) (kept, dropped, truncated []*FileEntry, report budgetReport) {
+	headers := make([]int, len(entries))
+	bodies := make([]int, len(entries))
+	largest := 0
+	for i, entry := range entries {
+		headers[i] = headerTokens(entry, opts)
+		bodies[i] = opts.tokenizer.count(entry.content)
+		largest = max(largest, bodies[i])
+	}
+	marker := opts.tokenizer.count([]byte(truncationMarker(999999, 999999)))
+
+	// total is the cost of the first n entries with their content limited
+	// to limit tokens
+	total := func(n, limit int) int {
+		sum := 0
+		for i := range n {
+			sum += headers[i] + min(bodies[i], limit)
+			if bodies[i] > limit {
+				sum += marker
+			}
+		}
+		return sum
+	}
+
+	// Keep the entries whose headers and markers fit even with no content
+	n, least := 0, 0
+	for n < len(entries) {
+		cost := headers[n]
+		if bodies[n] > 0 {
+			cost += marker
+		}
+		if least+cost > budget {
+			break
+		}
+		least += cost
+		n++
+	}
+	lo, hi := 0, largest
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if total(n, mid) <= budget {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	limit := lo
+
+	used := 0
+	for i, entry := range entries {
+		if i >= n {
+			dropped = append(dropped, entry)
+			continue
+		}
+		if bodies[i] > limit {
+			// The manifest and tree hash describe the content written
+			entry.content = truncateContent(entry.content, limit, opts)
+			hashContent(entry, opts)
+			truncated = append(truncated, entry)
+		}
+		used += headers[i] + opts.tokenizer.count(entry.content)
+		kept = append(kept, entry)
+	}
+	return kept, dropped, truncated, budgetReport{budget: budget, used: used, chosen: len(kept), candidates: len(entries), truncated: len(truncated)}
+}
+
+// truncateContent keeps the most whole lines of content that fit in limit
+// tokens and appends the truncation marker. The result is a new slice, so
+// the original may be released.
+func truncateContent(content []byte, limit int, opts *Options) []byte {
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+
+	lo, hi := 0, lines
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if opts.tokenizer.count(content[:lineStart(content, mid)]) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	kept := content[:lineStart(content, lo)]
+	out := make([]byte, 0, len(kept)+64)
+	out = append(out, kept...)
+	return append(out, truncationMarker(lo, lines)...)
+}
+
+// tokenReport is the per-file breakdown printed by -token-report, with the
+// counts taken before any budget was applied
+type tokenReport struct {
+	tokenizer string
+	entries   []*FileEntry
+	tokens    map[*FileEntry]int
+	status    map[*FileEntry]string
+}
+
+func newTokenReport(entries []*FileEntry, opts *Options) *tokenReport {
+	r := &tokenReport{
+		tokenizer: opts.tokenizer.name,
+		entries:   slices.Clone(entries),
+		tokens:    make(map[*FileEntry]int, len(entries)),
+		status:    make(map[*FileEntry]string),
+	}
+	for _, entry := range entries {
+		r.tokens[entry] = entryTokens(entry, opts)
+	}
+	return r
+}
+
+// mark notes what the budget did to entries, e.g. "dropped"
+func (r *tokenReport) mark(entries []*FileEntry, status string) {
+	for _, entry := range entries {
+		r.status[entry] = status
+	}
+}
+
+// print lists the files from most to fewest tokens, then the total
+func (r *tokenReport) print(w io.Writer, opts *Options) {
+	sorted := slices.Clone(r.entries)
+	slices.SortStableFunc(sorted, func(a, b *FileEntry) int {
+		return r.tokens[b] - r.tokens[a]
+	})
+
+	total := 0
+	fmt.Fprintf(w, "Token report (%s tokenizer):\n", r.tokenizer)
+	for _, entry := range sorted {
+		total += r.tokens[entry]
+		line := fmt.Sprintf("  %10d  %s", r.tokens[entry], displayPath(entry, opts))
+		if status := r.status[entry]; status != "" {
+			line += " (" + status + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "  %10d  total in %d files\n", total, len(sorted))
+}
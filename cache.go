@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cacheVersion names the subdirectory of -cache-dir holding the current
+// format, so a format change never reads records written by older versions
+const cacheVersion = "v1"
+
+// cacheNeutralFlags do not affect how a file's content is read or
+// transformed, so changing them keeps the cache valid. Any other flag change
+// invalidates every record.
+var cacheNeutralFlags = map[string]bool{
+	"output": true, "clipboard": true, "clipboard-max-bytes": true, "force": true,
+	"post-to": true, "header": true, "format": true, "json-with-stats": true,
+	"workers": true, "progress": true, "verbose": true, "summary-by-dir": true,
+	"size-histogram": true, "compress": true, "compression-level": true,
+	"cache-dir": true, "clear-cache": true, "skip-recent": true,
+	"tokenizer": true, "token-report": true, "watch": true, "section-index": true,
+}
+
+// cacheRecord is one file's entry in the cache, stored as JSON in
+// <cache-dir>/v1/<sha256 of the absolute path>.json. A record is used only
+// when the file's size and modification time and the options fingerprint
+// all match; content holds the file after transforms.
+type cacheRecord struct {
+	Path       string          `json:"path"`
+	Size       int64           `json:"size"`
+	ModTime    int64           `json:"mtime_ns"`
+	Options    string          `json:"options"`
+	Hash       string          `json:"hash"`
+	ChangeHash string          `json:"change_hash,omitempty"`
+	Content    []byte          `json:"content,omitempty"`
+	OmitReason string          `json:"omit_reason,omitempty"`
+	SkipReason string          `json:"skip_reason,omitempty"`
+	SkipDetail string          `json:"skip_detail,omitempty"`
+	Syntax     string          `json:"syntax_error,omitempty"`
+	Imports    []string        `json:"imports,omitempty"`
+	Warnings   []string        `json:"warnings,omitempty"`
+	Encoding   *cachedEncoding `json:"encoding,omitempty"`
+	Secrets    []cachedSecret  `json:"secrets,omitempty"`
+}
+
+type cachedEncoding struct {
+	Name       string  `json:"name"`
+	Confidence float64 `json:"confidence"`
+}
+
+type cachedSecret struct {
+	Kind  string `json:"kind"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Line  int    `json:"line"`
+}
+
+// fileCache lets unchanged files be emitted without reading and transforming
+// them again. Workers use it concurrently; each touches only its own files'
+// records.
+type fileCache struct {
+	dir     string
+	options string
+}
+
+// newFileCache opens the cache under dir, emptying it first if clear is set
+func newFileCache(dir string, clear bool) (*fileCache, error) {
+	dir = filepath.Join(dir, cacheVersion)
+	if clear {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var settings []string
+	flag.VisitAll(func(f *flag.Flag) {
+		if !cacheNeutralFlags[f.Name] {
+			settings = append(settings, f.Name+"="+f.Value.String())
+		}
+	})
+	sum := sha256.Sum256([]byte(strings.Join(settings, "\x00")))
+	return &fileCache{dir: dir, options: hex.EncodeToString(sum[:])}, nil
+}
+
+// recordPath returns where the record for the file at path is stored
+func (c *fileCache) recordPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load returns the cached entry for the file, or nil if there is none or it
+// is stale
+func (c *fileCache) load(path, relPath string, info os.FileInfo) *FileEntry {
+	data, err := os.ReadFile(c.recordPath(path))
+	if err != nil {
+		return nil
+	}
+	var rec cacheRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil
+	}
+	if rec.Size != info.Size() || rec.ModTime != info.ModTime().UnixNano() || rec.Options != c.options {
+		return nil
+	}
+
+	entry := &FileEntry{
+		path:        path,
+		relPath:     relPath,
+		info:        info,
+		content:     rec.Content,
+		hash:        rec.Hash,
+		changeHash:  rec.ChangeHash,
+		omitReason:  rec.OmitReason,
+		skipReason:  rec.SkipReason,
+		skipDetail:  rec.SkipDetail,
+		syntaxError: rec.Syntax,
+		imports:     rec.Imports,
+		warnings:    rec.Warnings,
+	}
+	if rec.Encoding != nil {
+		entry.encoding = &encodingGuess{name: rec.Encoding.Name, confidence: rec.Encoding.Confidence}
+	}
+	for _, s := range rec.Secrets {
+		entry.secrets = append(entry.secrets, secretFinding{kind: s.Kind, start: s.Start, end: s.End, line: s.Line})
+	}
+	return entry
+}
+
+// store records a freshly processed entry. Failures only cost a cache miss
+// on the next run, so they are ignored.
+func (c *fileCache) store(entry *FileEntry) {
+	rec := cacheRecord{
+		Path:       entry.path,
+		Size:       entry.info.Size(),
+		ModTime:    entry.info.ModTime().UnixNano(),
+		Options:    c.options,
+		Hash:       entry.hash,
+		ChangeHash: entry.changeHash,
+		Content:    entry.content,
+		OmitReason: entry.omitReason,
+		SkipReason: entry.skipReason,
+		SkipDetail: entry.skipDetail,
+		Syntax:     entry.syntaxError,
+		Imports:    entry.imports,
+		Warnings:   entry.warnings,
+	}
+	if guess := entry.encoding; guess != nil {
+		rec.Encoding = &cachedEncoding{Name: guess.name, Confidence: guess.confidence}
+	}
+	for _, s := range entry.secrets {
+		rec.Secrets = append(rec.Secrets, cachedSecret{Kind: s.kind, Start: s.start, End: s.end, Line: s.line})
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so a concurrent run never reads a
+	// partial record
+	path := c.recordPath(entry.path)
+	tmp, err := os.CreateTemp(c.dir, "record-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// process returns the cached entry for the file when it is still valid, and
+// otherwise processes the file and caches the result
+func (c *fileCache) process(path, relPath string, info os.FileInfo, opts *Options) (*FileEntry, error) {
+	if info.IsDir() {
+		return nil, nil
+	}
+	if entry := c.load(path, relPath, info); entry != nil {
+		return entry, nil
+	}
+
+	entry, err := processFile(path, relPath, info, opts)
+	// Files skipped as in flux are expected to change, so they are not cached
+	if err == nil && entry != nil && entry.skipReason != skipInFlux {
+		c.store(entry)
+	}
+	return entry, err
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Boundaries accepted by -split-boundary, from finest to coarsest
+const (
+	boundaryLine      = "line"
+	boundaryParagraph = "paragraph"
+	boundaryFile      = "file"
+)
+
+// chunkSegment is the rendered output for one file, a merged group, or the
+// run header and footer (which carry no paths)
+type chunkSegment struct {
+	paths []string
+	data  []byte
+}
+
+// chunker captures rendered output segment by segment so it can be re-cut
+// into token-bounded chunk files once the run is complete
+type chunker struct {
+	segments []*chunkSegment
+}
+
+// begin starts a new segment for the given source paths
+func (c *chunker) begin(paths ...string) {
+	c.segments = append(c.segments, &chunkSegment{paths: paths})
+}
+
+func (c *chunker) Write(p []byte) (int, error) {
+	if len(c.segments) == 0 {
+		c.begin()
+	}
+	seg := c.segments[len(c.segments)-1]
+	seg.data = append(seg.data, p...)
+	return len(p), nil
+}
+
+// chunkSpan records which bytes of a chunk came from which source file
+type chunkSpan struct {
+	path       string
+	start, end int
+}
+
+type chunk struct {
+	data  []byte
+	spans []chunkSpan
+}
+
+// files returns the distinct source paths a chunk spans, in order
+func (c *chunk) files() []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, span := range c.spans {
+		if !seen[span.path] {
+			seen[span.path] = true
+			paths = append(paths, span.path)
+		}
+	}
+	return paths
+}
+
+// prefixOffset returns the length of the longest rune-aligned prefix of data
+// that fits in the given number of tokens
+func prefixOffset(data []byte, tokens int, count func([]byte) int) int {
+	lo, hi := 0, len(data)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if count(data[:mid]) <= tokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	for lo > 0 && lo < len(data) && !utf8.RuneStart(data[lo]) {
+		lo--
+	}
+	return lo
+}
+
+// tailOffset returns the start of the longest rune-aligned suffix of data
+// that fits in the given number of tokens
+func tailOffset(data []byte, tokens int, count func([]byte) int) int {
+	lo, hi := 0, len(data)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if count(data[mid:]) <= tokens {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	for lo < len(data) && !utf8.RuneStart(data[lo]) {
+		lo++
+	}
+	return lo
+}
+
+// snapCut moves a cut offset in data back to the end of the last paragraph or
+// line before it, keeping the original offset when there is none
+func snapCut(data []byte, cut int, boundary string) int {
+	switch boundary {
+	case boundaryParagraph:
+		if i := bytes.LastIndex(data[:cut], []byte("\n\n")); i >= 0 {
+			return i + 2
+		}
+		fallthrough
+	case boundaryLine:
+		if i := bytes.LastIndexByte(data[:cut], '\n'); i >= 0 {
+			return i + 1
+		}
+	}
+	return cut
+}
+
+// snapOverlap moves the start of an overlap in data forward to the next line
+// start, keeping the original offset when no line starts in the overlap
+func snapOverlap(data []byte, start int) int {
+	if start == 0 || data[start-1] == '\n' {
+		return start
+	}
+	if i := bytes.IndexByte(data[start:], '\n'); i >= 0 && start+i+1 < len(data) {
+		return start + i + 1
+	}
+	return start
+}
+
+// split cuts the captured output into chunks of at most budget tokens, each
+// starting with the last overlap tokens of the previous chunk. Segments are
+// kept whole when they fit so chunks break on file boundaries where possible;
+// larger segments are cut at the last line or paragraph end that fits, or
+// not at all for the file boundary, which lets such a chunk exceed the budget.
+func (c *chunker) split(budget, overlap int, boundary string, count func([]byte) int) []*chunk {
+	var chunks []*chunk
+	cur := &chunk{}
+	seeded := 0
+
+	flush := func() {
+		chunks = append(chunks, cur)
+		next := &chunk{}
+		if overlap > 0 {
+			start := snapOverlap(cur.data, tailOffset(cur.data, overlap, count))
+			next.data = append(next.data, cur.data[start:]...)
+			for _, span := range cur.spans {
+				if span.end > start {
+					next.spans = append(next.spans, chunkSpan{span.path, max(span.start, start) - start, span.end - start})
+				}
+			}
+		}
+		cur = next
+		seeded = len(next.data)
+	}
+
+	add := func(paths []string, data []byte) {
+		start := len(cur.data)
+		cur.data = append(cur.data, data...)
+		for _, path := range paths {
+			cur.spans = append(cur.spans, chunkSpan{path, start, len(cur.data)})
+		}
+	}
+
+	for _, seg := range c.segments {
+		data := seg.data
+		for len(data) > 0 {
+			room := budget - count(cur.data)
+			if count(data) <= room {
+				add(seg.paths, data)
+				break
+			}
+
+			// Start a fresh chunk before cutting into the segment
+			if len(cur.data) > seeded {
+				flush()
+				continue
+			}
+
+			cut := len(data)
+			if boundary != boundaryFile {
+				cut = snapCut(data, prefixOffset(data, room, count), boundary)
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRune(data)
+			}
+			add(seg.paths, data[:cut])
+			data = data[cut:]
+			flush()
+		}
+	}
+
+	if len(cur.data) > seeded {
+		chunks = append(chunks, cur)
+	}
+	return chunks
+}
+
+// chunkBudget returns the size limit for chunk files and the function that
+// measures it: estimated tokens for -chunk-tokens or bytes for -split-size.
+// The budget is zero when chunking is off.
+func chunkBudget(opts *Options) (int, func([]byte) int) {
+	if opts.splitSize > 0 {
+		return opts.splitSize, func(b []byte) int { return len(b) }
+	}
+	return opts.chunkTokens, opts.tokenizer.count
+}
+
+// chunkPath derives the file name of chunk n from the output path
+func chunkPath(outputPath string, n int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.chunk-%03d%s", strings.TrimSuffix(outputPath, ext), n, ext)
+}
+
+// chunkIndexPath derives the name of the chunk index from the output path
+func chunkIndexPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".index.json"
+}
+
+// isOutputSibling reports whether path names one of the files written next
+// to the output at outputPath: a chunk file, the chunk index, a shard file or
+// the shard map. Both paths are absolute.
+func isOutputSibling(outputPath, path string) bool {
+	ext := filepath.Ext(outputPath)
+	rest, ok := strings.CutPrefix(path, strings.TrimSuffix(outputPath, ext)+".")
+	if !ok {
+		return false
+	}
+	if rest == "index.json" || rest == "shards.txt" {
+		return true
+	}
+	for _, kind := range []string{"chunk-", "shard-"} {
+		if n, ok := strings.CutPrefix(rest, kind); ok {
+			n, ok = strings.CutSuffix(n, ext)
+			return ok && n != "" && strings.Trim(n, "0123456789") == ""
+		}
+	}
+	return false
+}
+
+// removeStaleSiblings deletes the kind ("chunk" or "shard") files an earlier
+// run wrote next to the output, so that a run writing fewer of them leaves
+// none of the old ones behind
+func removeStaleSiblings(outputPath, kind string) error {
+	absOutputPath, err := filepath.Abs(outputPath)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(absOutputPath)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	prefix := strings.TrimSuffix(filepath.Base(absOutputPath), filepath.Ext(absOutputPath)) + "." + kind + "-"
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || !isOutputSibling(absOutputPath, path) {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkIndexFile is the index entry for one chunk file. Start and end are
+// byte offsets into the chunk file itself, header included, so a consumer
+// can read a source file's bytes with a single seek.
+type chunkIndexFile struct {
+	File  string           `json:"file"`
+	Bytes int              `json:"bytes"`
+	Files []chunkIndexSpan `json:"files"`
+}
+
+type chunkIndexSpan struct {
+	Path  string `json:"path"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+// writeChunks writes each chunk to its own file with a short header naming
+// the source files it spans, and then an index of which bytes of which chunk
+// came from each source file. It returns the chunk paths written.
+func writeChunks(outputPath string, chunks []*chunk) ([]string, error) {
+	var paths []string
+	if err := removeStaleSiblings(outputPath, "chunk"); err != nil {
+		return paths, err
+	}
+
+	index := struct {
+		Chunks []chunkIndexFile `json:"chunks"`
+	}{Chunks: []chunkIndexFile{}}
+	for i, c := range chunks {
+		path := chunkPath(outputPath, i+1)
+		header := fmt.Sprintf("# Chunk %d of %d\n# Files: %s\n\n", i+1, len(chunks), strings.Join(c.files(), ", "))
+
+		data := append([]byte(header), c.data...)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+
+		entry := chunkIndexFile{File: filepath.Base(path), Bytes: len(data), Files: []chunkIndexSpan{}}
+		for _, span := range c.spans {
+			entry.Files = append(entry.Files, chunkIndexSpan{span.path, len(header) + span.start, len(header) + span.end})
+		}
+		index.Chunks = append(index.Chunks, entry)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return paths, err
+	}
+	return paths, os.WriteFile(chunkIndexPath(outputPath), append(data, '\n'), 0644)
+}
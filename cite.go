@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// assignCiteIndices numbers the entries from 1 in the order they will be
+// written. Since -cite-index forces sorted output, the same inputs always
+// get the same numbers.
+func assignCiteIndices(items []outputItem) []*FileEntry {
+	var numbered []*FileEntry
+	for _, item := range items {
+		var entries []*FileEntry
+		switch {
+		case item.group != nil:
+			entries = item.group.entries
+		case item.entry != nil:
+			entries = []*FileEntry{item.entry}
+		}
+		for _, entry := range entries {
+			numbered = append(numbered, entry)
+			entry.citeIndex = len(numbered)
+		}
+	}
+	return numbered
+}
+
+// writeCiteIndex writes the table mapping citation numbers to paths that
+// precedes the file contents
+func writeCiteIndex(out io.Writer, entries []*FileEntry, opts *Options) error {
+	var table strings.Builder
+	table.WriteString("# File Index:\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&table, "#   [%d] %s\n", entry.citeIndex, displayPath(entry, opts))
+	}
+	table.WriteString("\n")
+
+	_, err := io.WriteString(out, table.String())
+	return err
+}
+
+// fileLabel returns the label used in file headers, carrying the citation
+// number when one was assigned
+func fileLabel(entry *FileEntry) string {
+	if entry.citeIndex > 0 {
+		return fmt.Sprintf("File [%d]", entry.citeIndex)
+	}
+	return "File"
+}
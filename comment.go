@@ -0,0 +1,72 @@
+package main
+
+// Fallbacks accepted by -comment-header-fallback for files whose language
+// has no known comment syntax
+const (
+	fallbackHash  = "hash"
+	fallbackPlain = "plain"
+	fallbackNone  = "none"
+)
+
+// commentSyntax is the line comment prefix, or block comment delimiters,
+// used to write file headers in a language's own syntax
+type commentSyntax struct {
+	prefix string
+	suffix string
+}
+
+var commentSyntaxByLanguage = map[string]commentSyntax{
+	"go":         {"// ", ""},
+	"javascript": {"// ", ""},
+	"typescript": {"// ", ""},
+	"rust":       {"// ", ""},
+	"c":          {"// ", ""},
+	"cpp":        {"// ", ""},
+	"java":       {"// ", ""},
+	"kotlin":     {"// ", ""},
+	"swift":      {"// ", ""},
+	"csharp":     {"// ", ""},
+	"php":        {"// ", ""},
+	"protobuf":   {"// ", ""},
+	"scss":       {"// ", ""},
+	"python":     {"# ", ""},
+	"ruby":       {"# ", ""},
+	"shell":      {"# ", ""},
+	"powershell": {"# ", ""},
+	"yaml":       {"# ", ""},
+	"toml":       {"# ", ""},
+	"makefile":   {"# ", ""},
+	"dockerfile": {"# ", ""},
+	"ini":        {"; ", ""},
+	"sql":        {"-- ", ""},
+	"lua":        {"-- ", ""},
+	"vim":        {`" `, ""},
+	"css":        {"/* ", " */"},
+	"html":       {"<!-- ", " -->"},
+	"xml":        {"<!-- ", " -->"},
+	"markdown":   {"<!-- ", " -->"},
+}
+
+// plainHeader is the default header style, which is not valid syntax in any
+// particular language
+var plainHeader = commentSyntax{"### ", ""}
+
+// headerSyntax returns how the header lines of the file at relPath are
+// written. The second result is false when the header is left out entirely.
+func headerSyntax(relPath string, opts *Options) (commentSyntax, bool) {
+	if !opts.commentHeaders {
+		return plainHeader, true
+	}
+	if syntax, ok := commentSyntaxByLanguage[detectLanguage(relPath)]; ok {
+		return syntax, true
+	}
+
+	switch opts.commentHeaderFallback {
+	case fallbackHash:
+		return commentSyntax{"# ", ""}, true
+	case fallbackNone:
+		return commentSyntax{}, false
+	default:
+		return plainHeader, true
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestHeaderSyntax(t *testing.T) {
+	tests := []struct {
+		path     string
+		headers  bool
+		fallback string
+		want     commentSyntax
+		wantOK   bool
+	}{
+		// Without -comment-headers every file gets the plain header
+		{"main.go", false, fallbackPlain, plainHeader, true},
+		{"LICENSE", false, fallbackNone, plainHeader, true},
+
+		// Known languages use their own syntax whatever the fallback
+		{"main.go", true, fallbackNone, commentSyntax{"// ", ""}, true},
+		{"style.css", true, fallbackHash, commentSyntax{"/* ", " */"}, true},
+
+		// Well-known extensionless names are recognized by name
+		{"Makefile", true, fallbackNone, commentSyntax{"# ", ""}, true},
+		{"docker/Dockerfile", true, fallbackNone, commentSyntax{"# ", ""}, true},
+		{"Gemfile", true, fallbackPlain, commentSyntax{"# ", ""}, true},
+
+		// Other extensionless files take the fallback
+		{"LICENSE", true, fallbackPlain, plainHeader, true},
+		{"LICENSE", true, fallbackHash, commentSyntax{"# ", ""}, true},
+		{"LICENSE", true, fallbackNone, commentSyntax{}, false},
+		{"scripts/run", true, fallbackHash, commentSyntax{"# ", ""}, true},
+		{"scripts/run", true, fallbackNone, commentSyntax{}, false},
+
+		// As do files with an unknown extension
+		{"data.xyz", true, fallbackHash, commentSyntax{"# ", ""}, true},
+		{"data.xyz", true, fallbackPlain, plainHeader, true},
+	}
+
+	for _, tt := range tests {
+		opts := &Options{commentHeaders: tt.headers, commentHeaderFallback: tt.fallback}
+		got, ok := headerSyntax(tt.path, opts)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("headerSyntax(%q) with headers=%t fallback=%s = %q, %t; want %q, %t",
+				tt.path, tt.headers, tt.fallback, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
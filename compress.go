@@ -0,0 +1,55 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Compression modes accepted by -compress
+const (
+	compressNone = "none"
+	compressGzip = "gzip"
+	compressZip  = "zip"
+)
+
+// zipEntryWriter writes a single file into a zip archive; closing it
+// finishes the archive
+type zipEntryWriter struct {
+	io.Writer
+	archive *zip.Writer
+}
+
+func (w *zipEntryWriter) Close() error {
+	return w.archive.Close()
+}
+
+// newCompressor wraps w so everything written to it is compressed according
+// to -compress and -compression-level. Close must be called to flush the
+// compressed stream; it does not close w.
+func newCompressor(w io.Writer, opts *Options) (io.WriteCloser, error) {
+	switch opts.compress {
+	case compressGzip:
+		return gzip.NewWriterLevel(w, opts.compressionLevel)
+	case compressZip:
+		archive := zip.NewWriter(w)
+		archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, opts.compressionLevel)
+		})
+
+		// The archive holds one file named after the output without ".zip"
+		name := strings.TrimSuffix(filepath.Base(opts.outputPath), ".zip")
+		if name == "" {
+			name = "combined_output.txt"
+		}
+		entry, err := archive.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		return &zipEntryWriter{Writer: entry, archive: archive}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected when classifying its content,
+// matching the amount git looks at
+const sniffLen = 8000
+
+// isBinary reports whether content looks binary, using git's heuristic of a
+// NUL byte within the first few kilobytes
+func isBinary(content []byte) bool {
+	if len(content) > sniffLen {
+		content = content[:sniffLen]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// maxInvalidShare is the share of invalid UTF-8 bytes in the sniffed prefix
+// above which content without NUL bytes is still treated as binary
+const maxInvalidShare = 0.3
+
+// looksBinary extends isBinary to content that has no NUL bytes but is
+// mostly invalid UTF-8, such as compressed data
+func looksBinary(content []byte) bool {
+	if isBinary(content) {
+		return true
+	}
+
+	sample := content
+	if len(sample) > sniffLen {
+		sample = sample[:sniffLen]
+	}
+	invalid := 0
+	for offset := 0; offset < len(sample); {
+		r, size := utf8.DecodeRune(sample[offset:])
+		// A rune cut off by the end of the sample is not counted as invalid
+		if r == utf8.RuneError && size == 1 && (len(content) == len(sample) || len(sample)-offset >= utf8.UTFMax) {
+			invalid++
+		}
+		offset += size
+	}
+	return float64(invalid) > maxInvalidShare*float64(len(sample))
+}
+
+// firstInvalidUTF8 returns the byte offset of the first invalid UTF-8
+// sequence in content, or -1 if it is entirely valid
+func firstInvalidUTF8(content []byte) int {
+	if utf8.Valid(content) {
+		return -1
+	}
+
+	for offset := 0; offset < len(content); {
+		r, size := utf8.DecodeRune(content[offset:])
+		if r == utf8.RuneError && size == 1 {
+			return offset
+		}
+		offset += size
+	}
+	return -1
+}
+
+// minifiedThresholds tunes the looksMinified heuristic
+type minifiedThresholds struct {
+	// minSize is the size below which content is never considered minified
+	minSize int
+	// maxAvgLineLength is the average line length above which content is
+	// considered minified
+	maxAvgLineLength int
+	// longLine and longLineShare catch files that are mostly one huge line
+	// behind a short readable preamble such as a license comment
+	longLine      int
+	longLineShare float64
+}
+
+var defaultMinifiedThresholds = minifiedThresholds{
+	minSize:          1024,
+	maxAvgLineLength: 300,
+	longLine:         1000,
+	longLineShare:    0.5,
+}
+
+// looksMinified reports whether content appears to be a minified asset, using
+// the default thresholds
+func looksMinified(content []byte) bool {
+	return defaultMinifiedThresholds.looksMinified(content)
+}
+
+func (t minifiedThresholds) looksMinified(content []byte) bool {
+	if len(content) < t.minSize {
+		return false
+	}
+
+	lines := bytes.Count(content, []byte("\n")) + 1
+	if len(content)/lines > t.maxAvgLineLength {
+		return true
+	}
+
+	longest := 0
+	for rest := content; len(rest) > 0; {
+		idx := bytes.IndexByte(rest, '\n')
+		if idx < 0 {
+			idx = len(rest)
+		}
+		longest = max(longest, idx)
+		rest = rest[min(idx+1, len(rest)):]
+	}
+	return longest > t.longLine && float64(longest) > t.longLineShare*float64(len(content))
+}
+
+// lockfileThresholds tunes the lockfile-like heuristic
+type lockfileThresholds struct {
+	// minLines is the length below which content is never considered a
+	// lockfile, since short files are cheap to include either way
+	minLines int
+	// maxShapeRatio is the highest number of distinct line shapes per line
+	// for content to count as repetitive
+	maxShapeRatio float64
+	// minHashShare is the share of lines carrying checksums needed when the
+	// content has no generated-file header
+	minHashShare float64
+}
+
+var defaultLockfileThresholds = lockfileThresholds{
+	minLines:      200,
+	maxShapeRatio: 0.1,
+	minHashShare:  0.1,
+}
+
+// lockfileStats holds the measurements the lockfile heuristic decides on
+type lockfileStats struct {
+	lines     int
+	shapes    int
+	hashLines int
+	generated bool
+}
+
+func (s lockfileStats) String() string {
+	if s.lines == 0 {
+		return "empty"
+	}
+	return fmt.Sprintf("%d lines, %.1f%% distinct shapes, %.1f%% with checksums, generated header: %t",
+		s.lines, 100*float64(s.shapes)/float64(s.lines), 100*float64(s.hashLines)/float64(s.lines), s.generated)
+}
+
+var (
+	lockfileHash      = regexp.MustCompile(`(?i)\b[0-9a-f]{40,}\b|\bsha(1|256|384|512)[-:]|\bh1:`)
+	lockfileGenerated = regexp.MustCompile(`(?i)generated|do not edit|not intended for manual editing`)
+)
+
+// lineShape reduces a line to its structure by collapsing every run of word,
+// version and checksum characters, so entries differing only in names,
+// versions or hashes share a shape
+func lineShape(line []byte) string {
+	shape := make([]byte, 0, 32)
+	inWord := false
+	for _, c := range line {
+		isWord := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '_' || c == '-' || c == '.' || c == '+' || c == '/' || c == '='
+		if isWord {
+			if !inWord {
+				shape = append(shape, 'a')
+			}
+		} else {
+			shape = append(shape, c)
+		}
+		inWord = isWord
+	}
+	return string(shape)
+}
+
+// measureLockfile collects the statistics used by the lockfile heuristic
+func measureLockfile(content []byte) lockfileStats {
+	var stats lockfileStats
+	shapes := make(map[string]bool)
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimRight(line, "\r")
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		stats.lines++
+		shapes[lineShape(line)] = true
+		if lockfileHash.Match(line) {
+			stats.hashLines++
+		}
+		if i < 5 && lockfileGenerated.Match(line) {
+			stats.generated = true
+		}
+	}
+	stats.shapes = len(shapes)
+	return stats
+}
+
+// looksLockfileLike reports whether stats describe dependency-lock content:
+// long, made of a few repeating line structures, and either marked as
+// generated or full of checksums
+func (t lockfileThresholds) looksLockfileLike(stats lockfileStats) bool {
+	if stats.lines < t.minLines {
+		return false
+	}
+	if float64(stats.shapes) > t.maxShapeRatio*float64(stats.lines) {
+		return false
+	}
+	return stats.generated || float64(stats.hashLines) >= t.minHashShare*float64(stats.lines)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLooksBinary(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(strings.Repeat("some compressible text\n", 200)))
+	zw.Close()
+
+	// The largest invalid share still treated as text, one byte short of a
+	// full sniffed prefix. Completed with a rune cut by the end of the
+	// prefix, the cut byte would tip it over if it counted as invalid.
+	atThreshold := strings.Repeat("\xe9a", int(maxInvalidShare*sniffLen))
+	atThreshold += strings.Repeat("a", sniffLen-1-len(atThreshold))
+	cutRune := atThreshold + "\u00e9" + strings.Repeat("a", 100)
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", false},
+		{"ascii text", "hello, world\n", false},
+		{"utf-8 text", "caf\u00e9 na\u00efve \u65e5\u672c\u8a9e \u263a\n", false},
+		{"NUL byte", "hello\x00world", true},
+		{"NUL byte past the sniffed prefix", strings.Repeat("a", sniffLen) + "\x00", false},
+		{"latin-1 text", "caf\xe9 na\xefve r\xe9sum\xe9 of a fa\xe7ade\n", false},
+		{"mostly high bytes", strings.Repeat("\xe9\xe8\xe0a", 100), true},
+		{"invalid share at the threshold", atThreshold + "a", false},
+		{"invalid share over the threshold", atThreshold + "\xe9", true},
+		{"utf-8 rune cut at the sniff boundary", cutRune, false},
+		{"gzip data", gz.String(), true},
+	}
+	for _, tt := range tests {
+		if got := looksBinary([]byte(tt.content)); got != tt.want {
+			t.Errorf("looksBinary(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLooksMinified(t *testing.T) {
+	source := strings.Repeat("func add(a, b int) int {\n\treturn a + b\n}\n\n", 60)
+	minifiedJS := strings.Repeat("var a=function(b,c){return b+c};", 200)
+	licensed := "/*!\n * library v1.2.3\n * (c) 2024 Someone\n * Released under the MIT License\n */\n" +
+		strings.Repeat("a.b=function(c){return c*2};", 120)
+	wideCSV := strings.Repeat(strings.Repeat("value,", 40)+"\n", 30)
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", false},
+		{"short single line", strings.Repeat("x", 900), false},
+		{"source code", source, false},
+		{"wide but line-broken data", wideCSV, false},
+		{"minified javascript", minifiedJS, true},
+		{"minified with trailing newline", minifiedJS + "\n", true},
+		{"minified behind a license comment", licensed, true},
+	}
+	for _, tt := range tests {
+		if got := looksMinified([]byte(tt.content)); got != tt.want {
+			t.Errorf("looksMinified(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMinifiedThresholds(t *testing.T) {
+	strict := minifiedThresholds{minSize: 10, maxAvgLineLength: 20, longLine: 40, longLineShare: 0.5}
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"short", false},
+		{strings.Repeat("abcdefghij", 5) + "\n", true},
+		{strings.Repeat("abc\n", 10), false},
+		{"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n" + strings.Repeat("z", 50), true},
+	}
+	for _, tt := range tests {
+		if got := strict.looksMinified([]byte(tt.content)); got != tt.want {
+			t.Errorf("looksMinified(%q) with strict thresholds = %t, want %t", tt.content, got, tt.want)
+		}
+	}
+}
+
+// lockfileLines builds n lines from a line template taking the line number
+func lockfileLines(n int, line func(i int) string) string {
+	var b strings.Builder
+	for i := range n {
+		b.WriteString(line(i))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func TestLooksLockfileLike(t *testing.T) {
+	hash := strings.Repeat("0123456789abcdef", 4)
+	yarnEntry := func(i int) string {
+		return fmt.Sprintf("pkg-%d@^1.%d.0:\n  version \"1.%d.3\"\n  resolved \"https://registry.example/pkg-%d.tgz#%s\"\n  integrity sha512-%s==\n",
+			i, i, i, i, hash, hash)
+	}
+	goSum := func(i int) string {
+		return fmt.Sprintf("example.com/mod%d v1.%d.0 h1:%s=", i, i, hash)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"empty", "", false},
+		{"generated and repetitive", "# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT.\n\n" + lockfileLines(100, yarnEntry), true},
+		{"checksummed and repetitive", lockfileLines(300, goSum), true},
+		{"too short", "# generated\n" + lockfileLines(50, goSum), false},
+		{"repetitive without header or checksums", lockfileLines(300, func(i int) string {
+			return fmt.Sprintf("entry%d = value%d", i, i)
+		}), false},
+		{"checksummed but varied", lockfileLines(300, func(i int) string {
+			return strings.Repeat("(", i%60) + "sum " + hash + strings.Repeat(")", i%7)
+		}), false},
+	}
+	for _, tt := range tests {
+		stats := measureLockfile([]byte(tt.content))
+		if got := defaultLockfileThresholds.looksLockfileLike(stats); got != tt.want {
+			t.Errorf("looksLockfileLike(%s) = %t, want %t (%s)", tt.name, got, tt.want, stats)
+		}
+	}
+}
+
+func TestMeasureLockfile(t *testing.T) {
+	content := "// Code generated by tool. DO NOT EDIT.\n\n" +
+		"a v1.0.0 h1:abc=\n" +
+		"b v2.3.4 h1:def=\n" +
+		"\n" +
+		"c = 1\n"
+	want := lockfileStats{lines: 4, shapes: 3, hashLines: 2, generated: true}
+	if got := measureLockfile([]byte(content)); got != want {
+		t.Errorf("measureLockfile = %+v, want %+v", got, want)
+	}
+
+	// A generated marker further down is not a file header
+	late := lockfileLines(10, func(i int) string { return "x" }) + "generated\n"
+	if got := measureLockfile([]byte(late)); got.generated {
+		t.Error("measureLockfile took a marker on line 11 for a generated-file header")
+	}
+}
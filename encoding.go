@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encodings recognized by detectEncoding
+const (
+	encodingUTF8    = "utf-8"
+	encodingUTF8BOM = "utf-8 (bom)"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingLatin1  = "iso-8859-1"
+)
+
+// lowEncodingConfidence is the confidence below which a transcoded file is
+// reported even without -verbose, since the guess may well be wrong
+const lowEncodingConfidence = 0.6
+
+// encodingGuess is the detected charset of a file and how sure the detector
+// is about it, from 0 to 1
+type encodingGuess struct {
+	name       string
+	confidence float64
+}
+
+// detectEncoding guesses the charset of content. A byte order mark is
+// trusted outright; otherwise UTF-16 is recognized by the NUL bytes its
+// ASCII characters leave in every other position, valid UTF-8 is taken as
+// is, and anything else is assumed to be Latin-1. The second result is
+// false for binary content that should be left alone.
+func detectEncoding(content []byte) (encodingGuess, bool) {
+	switch {
+	case bytes.HasPrefix(content, []byte{0xEF, 0xBB, 0xBF}):
+		return encodingGuess{encodingUTF8BOM, 1}, true
+	case bytes.HasPrefix(content, []byte{0xFF, 0xFE}):
+		return encodingGuess{encodingUTF16LE, 1}, true
+	case bytes.HasPrefix(content, []byte{0xFE, 0xFF}):
+		return encodingGuess{encodingUTF16BE, 1}, true
+	}
+
+	sample := content
+	if len(sample) > sniffLen {
+		sample = sample[:sniffLen]
+	}
+
+	if pairs := len(sample) / 2; pairs > 0 {
+		var evenNUL, oddNUL int
+		for i := 0; i+1 < len(sample); i += 2 {
+			if sample[i] == 0 {
+				evenNUL++
+			}
+			if sample[i+1] == 0 {
+				oddNUL++
+			}
+		}
+		even := float64(evenNUL) / float64(pairs)
+		odd := float64(oddNUL) / float64(pairs)
+		switch {
+		case odd > 0.3 && even < 0.05:
+			return encodingGuess{encodingUTF16LE, min(1, odd-even+0.3)}, true
+		case even > 0.3 && odd < 0.05:
+			return encodingGuess{encodingUTF16BE, min(1, even-odd+0.3)}, true
+		}
+	}
+
+	if isBinary(content) {
+		return encodingGuess{}, false
+	}
+	if utf8.Valid(content) {
+		return encodingGuess{encodingUTF8, 1}, true
+	}
+
+	// Bytes 0x80-0x9F are control codes in Latin-1 and rarely appear in real
+	// text, so their share of the high bytes lowers the confidence
+	var high, controls int
+	for _, b := range sample {
+		if b >= 0x80 {
+			high++
+			if b < 0xA0 {
+				controls++
+			}
+		}
+	}
+	confidence := 0.8
+	if high > 0 {
+		confidence *= 1 - float64(controls)/float64(high)
+	}
+	return encodingGuess{encodingLatin1, confidence}, true
+}
+
+// decodeToUTF8 converts content in the given encoding to UTF-8, dropping any
+// byte order mark
+func decodeToUTF8(content []byte, encoding string) []byte {
+	switch encoding {
+	case encodingUTF8BOM:
+		return content[3:]
+	case encodingUTF16LE, encodingUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		if encoding == encodingUTF16BE {
+			order = binary.BigEndian
+		}
+
+		units := make([]uint16, 0, len(content)/2)
+		for i := 0; i+1 < len(content); i += 2 {
+			units = append(units, order.Uint16(content[i:]))
+		}
+		if len(units) > 0 && units[0] == 0xFEFF {
+			units = units[1:]
+		}
+
+		decoded := make([]byte, 0, len(units))
+		for _, r := range utf16.Decode(units) {
+			decoded = utf8.AppendRune(decoded, r)
+		}
+		return decoded
+	case encodingLatin1:
+		decoded := make([]byte, 0, len(content)+len(content)/4)
+		for _, b := range content {
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+		return decoded
+	}
+	return content
+}
+
+// transcodeTransform converts files detected as another encoding to UTF-8
+// and records the detection on the entry for reporting
+func transcodeTransform(entry *FileEntry, content []byte) ([]byte, error) {
+	guess, ok := detectEncoding(content)
+	if !ok || guess.name == encodingUTF8 {
+		return content, nil
+	}
+
+	entry.encoding = &guess
+	return decodeToUTF8(content, guess.name), nil
+}
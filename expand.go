@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultIncludeDirective matches lines such as '// @include other.go' or
+// '# @include other.py'; the first capture group is the referenced path
+const defaultIncludeDirective = `^\s*(?://|#)\s*@include\s+(\S+)\s*$`
+
+// includeExpander inlines files referenced by include directives
+type includeExpander struct {
+	directive *regexp.Regexp
+	opts      *Options
+
+	// ignoreList is set once the run has loaded its ignore rules; targets
+	// it would drop are not inlined
+	ignoreList *IgnoreList
+}
+
+func newIncludeExpander(directive string, opts *Options) (*includeExpander, error) {
+	re, err := regexp.Compile(directive)
+	if err != nil {
+		return nil, fmt.Errorf("invalid include directive %q: %v", directive, err)
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("include directive %q needs a capture group for the path", directive)
+	}
+	return &includeExpander{directive: re, opts: opts}, nil
+}
+
+// expand replaces each directive line in content with the referenced file's
+// content, recursively. Paths resolve relative to the including file. A
+// directive that would form a cycle, points outside the scanned directory or
+// at an ignored file, or cannot be read is left in place and a warning is
+// added to entry.
+func (x *includeExpander) expand(entry *FileEntry, path string, content []byte, stack []string) []byte {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	stack = append(stack, abs)
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	out := make([]byte, 0, len(content))
+	for _, line := range lines {
+		m := x.directive.FindSubmatch(bytes.TrimRight(line, "\r\n"))
+		if m == nil {
+			out = append(out, line...)
+			continue
+		}
+
+		target := filepath.Join(filepath.Dir(path), filepath.FromSlash(string(m[1])))
+		included, err := x.load(entry, target, stack)
+		if err != nil {
+			entry.warnings = append(entry.warnings, fmt.Sprintf("not expanding include in %s: %v", path, err))
+			out = append(out, line...)
+			continue
+		}
+
+		out = append(out, included...)
+		if len(included) > 0 && included[len(included)-1] != '\n' {
+			out = append(out, '\n')
+		}
+	}
+	return out
+}
+
+func (x *includeExpander) load(entry *FileEntry, target string, stack []string) ([]byte, error) {
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, seen := range stack {
+		if seen == abs {
+			chain := append(append([]string(nil), stack[i:]...), abs)
+			for j := range chain {
+				chain[j] = filepath.Base(chain[j])
+			}
+			return nil, fmt.Errorf("include cycle %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	if err := x.checkTarget(target); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return nil, err
+	}
+	return x.expand(entry, target, data, stack), nil
+}
+
+// checkTarget refuses targets that resolve, through symlinks too, to a path
+// outside the scanned directory or that the ignore rules would drop
+func (x *includeExpander) checkTarget(target string) error {
+	resolved, err := resolvePath(target)
+	if err != nil {
+		return err
+	}
+	root, err := resolvePath(x.opts.dirPath)
+	if err != nil {
+		return err
+	}
+
+	relPath, err := filepath.Rel(root, resolved)
+	if err != nil || !filepath.IsLocal(relPath) {
+		return fmt.Errorf("%s is outside %s", target, x.opts.dirPath)
+	}
+
+	if x.ignoreList == nil {
+		return nil
+	}
+	var ignored bool
+	if sub, subPath := findSubmodule(x.opts.submodules, relPath); sub != nil {
+		ignored = sub.ignoreList.shouldIgnore(subPath)
+	} else {
+		ignored = x.ignoreList.shouldIgnore(relPath)
+	}
+	if ignored {
+		return fmt.Errorf("%s is ignored", target)
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of path with symlinks evaluated
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+func (x *includeExpander) transform(entry *FileEntry, content []byte) ([]byte, error) {
+	return x.expand(entry, entry.path, content, nil), nil
+}
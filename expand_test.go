@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandIncludesStaysInTree(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	files := map[string]string{
+		"outside.txt":          "outside\n",
+		"root/.gitignore":      "build/\n",
+		"root/build/gen.txt":   "generated\n",
+		"root/sub/inner.txt":   "inner\n",
+		"root/sub/nested.txt":  "// @include inner.txt\n",
+		"root/sub/escape.txt":  "// @include ../../outside.txt\n",
+		"root/sub/cycle_a.txt": "// @include cycle_b.txt\n",
+		"root/sub/cycle_b.txt": "// @include cycle_a.txt\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(base, "outside.txt"), filepath.Join(root, "sub", "link.txt")); err != nil {
+		t.Skipf("cannot create symlink: %v", err)
+	}
+
+	opts := &Options{dirPath: root}
+	x, err := newIncludeExpander(defaultIncludeDirective, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x.ignoreList = loadIgnoreLists(opts)
+
+	tests := []struct {
+		directive string
+		want      string
+		warning   string
+	}{
+		{"// @include sub/inner.txt", "inner\n", ""},
+		{"// @include sub/nested.txt", "inner\n", ""},
+		{"// @include ../outside.txt", "", "is outside"},
+		{"// @include sub/escape.txt", "// @include ../../outside.txt\n", "is outside"},
+		{"// @include sub/link.txt", "", "is outside"},
+		{"// @include build/gen.txt", "", "is ignored"},
+		{"// @include sub/cycle_a.txt", "// @include cycle_a.txt\n", "include cycle"},
+	}
+	for _, tt := range tests {
+		entry := &FileEntry{path: filepath.Join(root, "main.go")}
+		content := tt.directive + "\n"
+		got, err := x.transform(entry, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := tt.want
+		if tt.warning != "" && want == "" {
+			want = content
+		}
+		if string(got) != want {
+			t.Errorf("expanding %q = %q, want %q", tt.directive, got, want)
+		}
+
+		warnings := strings.Join(entry.warnings, "\n")
+		if tt.warning == "" && warnings != "" || !strings.Contains(warnings, tt.warning) {
+			t.Errorf("expanding %q warned %q, want a warning containing %q", tt.directive, warnings, tt.warning)
+		}
+	}
+}
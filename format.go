@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// formatWriter renders the combined output in one -format. begin is called
+// before any file and end after the last, with the run stats; end is also
+// where formats close their document.
+type formatWriter interface {
+	begin() error
+	writeEntry(entry *FileEntry) error
+	end(stats jsonStats) error
+}
+
+// contentsWriter is implemented by formats that list every file up front.
+// Such formats force sorted output so the list is complete before the first
+// file is written.
+type contentsWriter interface {
+	writeContents(entries []*FileEntry) error
+}
+
+// runInfo is the run metadata each format writes at the top of its output
+type runInfo struct {
+	generated time.Time // zero under -deterministic
+	source    string
+	runID     string
+	nonce     string
+}
+
+func newFormatWriter(out io.Writer, run runInfo, opts *Options) formatWriter {
+	switch opts.format {
+	case formatNDJSON, formatJSON:
+		return newNDJSONWriter(out, opts)
+	case formatMarkdown:
+		return &markdownWriter{out: out, run: run, opts: opts}
+	case formatXML:
+		return &xmlWriter{out: out, run: run, opts: opts}
+	}
+	return &textWriter{out: out, header: textHeader(run), opts: opts}
+}
+
+// textHeader returns the comment block opening -format text output
+func textHeader(run runInfo) string {
+	header := "# Combined File Contents\n"
+	if !run.generated.IsZero() {
+		header += fmt.Sprintf("# Generated: %s\n", run.generated.Format("2006-01-02 15:04:05"))
+	}
+	header += fmt.Sprintf("# Source Directory: %s\n", run.source)
+	if run.runID != "" {
+		header += fmt.Sprintf("# Run ID: %s\n", run.runID)
+	}
+	if run.nonce != "" {
+		header += fmt.Sprintf("# Separator Nonce: %s\n", run.nonce)
+	}
+	return header + "\n"
+}
+
+// entryBody returns the part of an entry's content that is written, along
+// with the number of lines left out by -preview-lines
+func entryBody(entry *FileEntry, opts *Options) ([]byte, int) {
+	switch {
+	case entry.omitReason != "":
+		return []byte(omitNote(entry)), 0
+	case opts.previewLines > 0:
+		return previewContent(entry.content, opts.previewLines)
+	case opts.sampleSections.total() > 0:
+		return sampleSections(entry.content, opts.sampleSections), 0
+	}
+	return entry.content, 0
+}
+
+// textWriter writes the original '### File:' format. Footers such as the
+// tree hash are written by main since only this format has them.
+type textWriter struct {
+	out    io.Writer
+	header string
+	opts   *Options
+}
+
+func (w *textWriter) begin() error {
+	_, err := io.WriteString(w.out, w.header)
+	return err
+}
+
+func (w *textWriter) writeEntry(entry *FileEntry) error {
+	return writeFileEntry(w.out, entry, w.opts)
+}
+
+func (w *textWriter) end(stats jsonStats) error {
+	return nil
+}
+
+// markdownWriter writes a Markdown document: the run metadata, a directory
+// tree and a linked table of contents, then one section per file with its
+// content in a fenced code block tagged with the file's language
+type markdownWriter struct {
+	out     io.Writer
+	run     runInfo
+	opts    *Options
+	anchors map[*FileEntry]string
+}
+
+func (w *markdownWriter) begin() error {
+	var b strings.Builder
+	b.WriteString("# Combined File Contents\n\n")
+	if !w.run.generated.IsZero() {
+		fmt.Fprintf(&b, "- Generated: %s\n", w.run.generated.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintf(&b, "- Source Directory: `%s`\n", w.run.source)
+	if w.run.runID != "" {
+		fmt.Fprintf(&b, "- Run ID: %s\n", w.run.runID)
+	}
+	_, err := io.WriteString(w.out, b.String())
+	return err
+}
+
+func (w *markdownWriter) writeContents(entries []*FileEntry) error {
+	w.anchors = make(map[*FileEntry]string, len(entries))
+
+	var b strings.Builder
+	b.WriteString("\n## Contents\n\n")
+
+	paths := make([]string, len(entries))
+	for i, entry := range entries {
+		paths[i] = filepath.ToSlash(entry.relPath)
+	}
+	root := "."
+	if w.opts.dirAlias != "" {
+		root = w.opts.dirAlias
+	}
+	b.WriteString("```text\n")
+	b.WriteString(renderTree(root, paths))
+	b.WriteString("```\n\n")
+
+	// Explicit anchors keep the links working whatever characters the
+	// paths contain
+	for i, entry := range entries {
+		anchor := fmt.Sprintf("file-%d", i+1)
+		w.anchors[entry] = anchor
+		fmt.Fprintf(&b, "%d. [%s](#%s)\n", i+1, displayPath(entry, w.opts), anchor)
+	}
+
+	_, err := io.WriteString(w.out, b.String())
+	return err
+}
+
+func (w *markdownWriter) writeEntry(entry *FileEntry) error {
+	var b strings.Builder
+	b.WriteString("\n")
+	if anchor, ok := w.anchors[entry]; ok {
+		fmt.Fprintf(&b, "<a id=\"%s\"></a>\n\n", anchor)
+	}
+	fmt.Fprintf(&b, "## %s\n\n", displayPath(entry, w.opts))
+	if entry.submodule != "" {
+		fmt.Fprintf(&b, "- Submodule: `%s`\n", entry.submodule)
+	}
+	fmt.Fprintf(&b, "- Size: %d bytes\n", entry.info.Size())
+	if !w.opts.deterministic {
+		fmt.Fprintf(&b, "- Last Modified: %s\n", entry.info.ModTime().Format("2006-01-02 15:04:05"))
+	}
+	if entry.syntaxError != "" {
+		fmt.Fprintf(&b, "- Syntax Error: %s\n", entry.syntaxError)
+	}
+	b.WriteString("\n")
+
+	if entry.omitReason != "" {
+		fmt.Fprintf(&b, "_%s_\n", strings.TrimSpace(strings.TrimPrefix(omitNote(entry), "### ")))
+		_, err := io.WriteString(w.out, b.String())
+		return err
+	}
+
+	content, omitted := entryBody(entry, w.opts)
+	fence := markdownFence(content)
+	fmt.Fprintf(&b, "%s%s\n", fence, detectLanguage(entry.relPath))
+	b.Write(content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		b.WriteString("\n")
+	}
+	b.WriteString(fence + "\n")
+	if omitted > 0 {
+		fmt.Fprintf(&b, "\n_%d more lines not shown_\n", omitted)
+	}
+
+	_, err := io.WriteString(w.out, b.String())
+	return err
+}
+
+func (w *markdownWriter) end(stats jsonStats) error {
+	return nil
+}
+
+// markdownFence returns a backtick fence longer than any backtick run in
+// content, so the content cannot close its own code block
+func markdownFence(content []byte) string {
+	longest, run := 0, 0
+	for _, c := range content {
+		if c == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+// renderTree draws slash-separated paths as an indented tree under root,
+// with directories before files at each level
+func renderTree(root string, paths []string) string {
+	type node struct {
+		children map[string]*node
+	}
+	top := &node{children: map[string]*node{}}
+	for _, p := range paths {
+		n := top
+		for _, part := range strings.Split(p, "/") {
+			child, ok := n.children[part]
+			if !ok {
+				child = &node{children: map[string]*node{}}
+				n.children[part] = child
+			}
+			n = child
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(root + "\n")
+	var draw func(n *node, prefix string)
+	draw = func(n *node, prefix string) {
+		names := make([]string, 0, len(n.children))
+		for name := range n.children {
+			names = append(names, name)
+		}
+		slices.SortFunc(names, func(a, b string) int {
+			aDir, bDir := len(n.children[a].children) > 0, len(n.children[b].children) > 0
+			if aDir != bDir {
+				if aDir {
+					return -1
+				}
+				return 1
+			}
+			return strings.Compare(a, b)
+		})
+
+		for i, name := range names {
+			child := n.children[name]
+			branch, indent := "├── ", "│   "
+			if i == len(names)-1 {
+				branch, indent = "└── ", "    "
+			}
+			if len(child.children) > 0 {
+				name += "/"
+			}
+			b.WriteString(prefix + branch + name + "\n")
+			draw(child, prefix+indent)
+		}
+	}
+	draw(top, "")
+	return b.String()
+}
+
+// xmlWriter writes a <files> document with one <file> element per file.
+// Content is escaped text rather than CDATA, which cannot hold ']]>' or
+// characters XML does not allow.
+type xmlWriter struct {
+	out  io.Writer
+	run  runInfo
+	opts *Options
+}
+
+func (w *xmlWriter) begin() error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString("<files")
+	writeXMLAttr(&b, "source", w.run.source)
+	if !w.run.generated.IsZero() {
+		writeXMLAttr(&b, "generated", w.run.generated.Format(time.RFC3339))
+	}
+	if w.run.runID != "" {
+		writeXMLAttr(&b, "run-id", w.run.runID)
+	}
+	b.WriteString(">\n")
+	_, err := io.WriteString(w.out, b.String())
+	return err
+}
+
+func (w *xmlWriter) writeEntry(entry *FileEntry) error {
+	var b strings.Builder
+	b.WriteString("  <file")
+	writeXMLAttr(&b, "path", displayPath(entry, w.opts))
+	if entry.submodule != "" {
+		writeXMLAttr(&b, "submodule", entry.submodule)
+	}
+	writeXMLAttr(&b, "size", fmt.Sprint(entry.info.Size()))
+	if !w.opts.deterministic {
+		writeXMLAttr(&b, "mtime", entry.info.ModTime().Format(time.RFC3339))
+	}
+	if lang := detectLanguage(entry.relPath); lang != "" {
+		writeXMLAttr(&b, "language", lang)
+	}
+	writeXMLAttr(&b, "hash", entry.hash)
+	if entry.syntaxError != "" {
+		writeXMLAttr(&b, "syntax-error", entry.syntaxError)
+	}
+	if entry.omitReason != "" {
+		writeXMLAttr(&b, "omitted", entry.omitReason)
+		b.WriteString("/>\n")
+		_, err := io.WriteString(w.out, b.String())
+		return err
+	}
+
+	content, omitted := entryBody(entry, w.opts)
+	if omitted > 0 {
+		writeXMLAttr(&b, "omitted-lines", fmt.Sprint(omitted))
+	}
+	b.WriteString("><content>")
+	writeXMLText(&b, content)
+	b.WriteString("</content></file>\n")
+
+	_, err := io.WriteString(w.out, b.String())
+	return err
+}
+
+func (w *xmlWriter) end(stats jsonStats) error {
+	_, err := io.WriteString(w.out, "</files>\n")
+	return err
+}
+
+// writeXMLText appends content escaped as element text. Unlike
+// xml.EscapeText it keeps line breaks and tabs literal so the content stays
+// readable; characters XML cannot represent become U+FFFD.
+func writeXMLText(b *strings.Builder, content []byte) {
+	for _, r := range string(content) {
+		switch {
+		case r == '&':
+			b.WriteString("&amp;")
+		case r == '<':
+			b.WriteString("&lt;")
+		case r == '>':
+			b.WriteString("&gt;")
+		case r == '\r':
+			b.WriteString("&#xD;")
+		case r == '\n' || r == '\t' || r >= 0x20 && r <= 0xD7FF || r >= 0xE000 && r <= 0xFFFD || r >= 0x10000 && r <= 0x10FFFF:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('\uFFFD')
+		}
+	}
+}
+
+// writeXMLAttr appends ' name="value"' with value escaped
+func writeXMLAttr(b *strings.Builder, name, value string) {
+	b.WriteString(" " + name + `="`)
+	xml.EscapeText(b, []byte(value))
+	b.WriteString(`"`)
+}
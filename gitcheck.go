@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// gitCheckBatchSize is the number of paths sent to each git check-ignore call
+const gitCheckBatchSize = 512
+
+// gitIgnoreChecker defers ignore decisions to git itself so nested .gitignore
+// files, .git/info/exclude, the global excludes file and rules above the
+// scanned directory all apply exactly as git applies them
+type gitIgnoreChecker struct {
+	dir string
+
+	// dirs is a git check-ignore process kept running for the walk, which
+	// answers one directory at a time; see ignoredDir
+	dirs *gitDirQuery
+}
+
+// gitDirQuery is a running 'git check-ignore --stdin' that reports on every
+// path it is sent, matching or not, as soon as it reads it
+type gitDirQuery struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+// newGitIgnoreChecker returns a checker for dir, or an error if dir is not
+// inside a git work tree
+func newGitIgnoreChecker(dir string) (*gitIgnoreChecker, error) {
+	cmd := exec.Command("git", "-C", dir, "rev-parse", "--is-inside-work-tree")
+	output, err := cmd.Output()
+	if err != nil || strings.TrimSpace(string(output)) != "true" {
+		return nil, fmt.Errorf("%s is not inside a git work tree", dir)
+	}
+	return &gitIgnoreChecker{dir: dir}, nil
+}
+
+// ignored returns the subset of relPaths that git ignores. Paths are relative
+// to the checker's directory and are sent in a single NUL-separated batch.
+func (c *gitIgnoreChecker) ignored(relPaths []string) (map[string]bool, error) {
+	var input bytes.Buffer
+	for _, relPath := range relPaths {
+		input.WriteString(filepath.ToSlash(relPath))
+		input.WriteByte(0)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "-C", c.dir, "check-ignore", "--stdin", "-z")
+	cmd.Stdin = &input
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// Exit status 1 means none of the paths are ignored
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("git check-ignore: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	ignored := make(map[string]bool)
+	for _, path := range strings.Split(string(output), "\x00") {
+		if path != "" {
+			ignored[filepath.FromSlash(path)] = true
+		}
+	}
+	return ignored, nil
+}
+
+// filter returns the paths git does not ignore, preserving their order.
+// Paths inside submodules are passed through since the superproject's git
+// refuses to answer for them; their own ignore lists apply in the workers.
+func (c *gitIgnoreChecker) filter(paths []string, opts *Options) ([]string, error) {
+	var query []string
+	for _, path := range paths {
+		relPath, err := filepath.Rel(opts.dirPath, path)
+		if err != nil || relPath == "." {
+			continue
+		}
+		if sub, _ := findSubmodule(opts.submodules, relPath); sub == nil {
+			query = append(query, relPath)
+		}
+	}
+	if len(query) == 0 {
+		return paths, nil
+	}
+
+	ignored, err := c.ignored(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(ignored) == 0 {
+		return paths, nil
+	}
+
+	kept := paths[:0]
+	for _, path := range paths {
+		relPath, err := filepath.Rel(opts.dirPath, path)
+		if err == nil && ignored[relPath] {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept, nil
+}
+
+// ignoredDir reports whether git ignores the directory relPath. The walk
+// asks as it reaches each directory, so an ignored one is pruned rather than
+// walked with every file in it sent to git; the answers come from a single
+// git process instead of one per directory.
+func (c *gitIgnoreChecker) ignoredDir(relPath string) (bool, error) {
+	if c.dirs == nil {
+		cmd := exec.Command("git", "-C", c.dir, "check-ignore", "--stdin", "-z", "--verbose", "--non-matching")
+		cmd.Env = append(os.Environ(), "GIT_FLUSH=1")
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return false, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return false, err
+		}
+		if err := cmd.Start(); err != nil {
+			return false, fmt.Errorf("git check-ignore: %v", err)
+		}
+		c.dirs = &gitDirQuery{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}
+	}
+
+	// The trailing slash lets directory-only patterns such as 'build/' match
+	if _, err := io.WriteString(c.dirs.stdin, filepath.ToSlash(relPath)+"/\x00"); err != nil {
+		return false, fmt.Errorf("git check-ignore: %v", err)
+	}
+
+	// Each answer is source, line number, pattern and path; the pattern is
+	// empty when nothing matched and starts with '!' when a negation did
+	var fields [4]string
+	for i := range fields {
+		field, err := c.dirs.stdout.ReadString(0)
+		if err != nil {
+			return false, fmt.Errorf("git check-ignore: %v", err)
+		}
+		fields[i] = strings.TrimSuffix(field, "\x00")
+	}
+	pattern := fields[2]
+	return pattern != "" && !strings.HasPrefix(pattern, "!"), nil
+}
+
+// close stops the directory query process, if one was started
+func (c *gitIgnoreChecker) close() {
+	if c.dirs == nil {
+		return
+	}
+	c.dirs.stdin.Close()
+	c.dirs.cmd.Wait()
+	c.dirs = nil
+}
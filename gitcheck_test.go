@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitIgnoreCheckerIgnoredDir(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	if out, err := exec.Command("git", "-C", dir, "init", "-q").CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	gitignore := "node_modules/\nbuild\n/dist/\ngen/\n!gen/\n"
+	if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte(gitignore), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := newGitIgnoreChecker(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checker.close()
+
+	tests := []struct {
+		dir  string
+		want bool
+	}{
+		{"node_modules", true},
+		{"src", false},
+		{filepath.Join("web", "node_modules"), true},
+		{filepath.Join("src", "build"), true},
+		{"dist", true},
+		{filepath.Join("src", "dist"), false},
+		{"gen", false},
+	}
+	for _, tt := range tests {
+		got, err := checker.ignoredDir(tt.dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("ignoredDir(%q) = %t, want %t", tt.dir, got, tt.want)
+		}
+	}
+}
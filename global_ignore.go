@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// globalExcludesFile returns the path of git's global excludes file: the
+// core.excludesfile setting as seen from dir if configured, otherwise git's
+// default of $XDG_CONFIG_HOME/git/ignore. It returns "" if neither exists.
+func globalExcludesFile(dir string) string {
+	var path string
+	if output, err := exec.Command("git", "-C", dir, "config", "--get", "core.excludesfile").Output(); err == nil {
+		path = strings.TrimSpace(string(output))
+	}
+
+	home, _ := os.UserHomeDir()
+	if path == "" {
+		configHome := os.Getenv("XDG_CONFIG_HOME")
+		if configHome == "" && home != "" {
+			configHome = filepath.Join(home, ".config")
+		}
+		if configHome != "" {
+			path = filepath.Join(configHome, "git", "ignore")
+		}
+	} else if rest, found := strings.CutPrefix(path, "~/"); found && home != "" {
+		path = filepath.Join(home, rest)
+	}
+
+	if path == "" {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// loadGlobalExcludes compiles git's global excludes file, returning a nil
+// matcher when none is configured
+func loadGlobalExcludes(dir string) (string, *gitignore.GitIgnore, error) {
+	path := globalExcludesFile(dir)
+	if path == "" {
+		return "", nil, nil
+	}
+
+	matcher, err := gitignore.CompileIgnoreFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	return path, matcher, nil
+}
+
+// addGlobalExcludes layers the global excludes into the list; the source is
+// recorded so -ignore-audit covers its patterns too
+func (il *IgnoreList) addGlobalExcludes(path string, matcher *gitignore.GitIgnore) {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.globalIgnore = matcher
+	il.sources = append(il.sources, path)
+}
@@ -2,4 +2,10 @@ module singlegen
 
 go 1.23.2
 
-require github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06 // indirect
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/sabhiram/go-gitignore v0.0.0-20210923224102-525f6e181f06
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// treeLeaf is a single (path, content hash) pair contributing to the tree hash
+// and manifest. changeHash, when set, stands in for hash in the tree hash
+// only, so manifest lines still verify with sha256sum.
+type treeLeaf struct {
+	path       string
+	hash       string
+	changeHash string
+}
+
+// hashContent sets an entry's hashes from its content as it will be written
+func hashContent(entry *FileEntry, opts *Options) {
+	sum := sha256.Sum256(entry.content)
+	entry.hash = hex.EncodeToString(sum[:])
+	if opts.hashIgnoreWhitespace {
+		entry.changeHash = whitespaceInsensitiveHash(entry.content)
+	}
+}
+
+// whitespaceInsensitiveHash hashes content with every run of whitespace
+// collapsed to a single space and leading and trailing whitespace dropped,
+// so reindenting or rewrapping a file leaves the hash unchanged. It is only
+// used for hashing; the content written is never normalized.
+func whitespaceInsensitiveHash(content []byte) string {
+	normalized := bytes.Join(bytes.Fields(content), []byte(" "))
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:])
+}
+
+// sortedLeaves returns a copy of leaves sorted by slash-separated path, so
+// Windows paths sort as they do elsewhere
+func sortedLeaves(leaves []treeLeaf) []treeLeaf {
+	sorted := make([]treeLeaf, len(leaves))
+	copy(sorted, leaves)
+	sort.Slice(sorted, func(i, j int) bool {
+		return filepath.ToSlash(sorted[i].path) < filepath.ToSlash(sorted[j].path)
+	})
+	return sorted
+}
+
+// writeManifest writes one sha256sum-style line per file, sorted by relative
+// path so the manifest is the same whatever order the files were written in
+// and on every platform
+func writeManifest(out io.Writer, leaves []treeLeaf) error {
+	sorted := sortedLeaves(leaves)
+	if _, err := io.WriteString(out, "\n# Manifest:\n"); err != nil {
+		return err
+	}
+	for _, leaf := range sorted {
+		if _, err := fmt.Fprintf(out, "# %s  %s\n", leaf.hash, filepath.ToSlash(leaf.path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// treeHash computes a Merkle-style root over the given leaves. Leaves are
+// sorted as for the manifest before combining so the result is independent
+// of the order in which workers finished and of the platform.
+func treeHash(leaves []treeLeaf) string {
+	sorted := sortedLeaves(leaves)
+
+	level := make([][]byte, 0, len(sorted))
+	for _, leaf := range sorted {
+		hash := leaf.hash
+		if leaf.changeHash != "" {
+			hash = leaf.changeHash
+		}
+		sum := sha256.Sum256([]byte("leaf\x00" + filepath.ToSlash(leaf.path) + "\x00" + hash))
+		level = append(level, sum[:])
+	}
+
+	if len(level) == 0 {
+		sum := sha256.Sum256(nil)
+		return hex.EncodeToString(sum[:])
+	}
+
+	// Combine pairs until a single root remains; an odd node is promoted as-is
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			h := sha256.New()
+			h.Write([]byte("node\x00"))
+			h.Write(level[i])
+			h.Write(level[i+1])
+			next = append(next, h.Sum(nil))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0])
+}
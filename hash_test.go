@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// manifestLines returns the manifest lines of a text output, without its
+// heading and the tree hash footer after it
+func manifestLines(t *testing.T, output []byte) []string {
+	t.Helper()
+	_, manifest, found := bytes.Cut(output, []byte("\n# Manifest:\n"))
+	if !found {
+		t.Fatalf("no manifest in output:\n%s", output)
+	}
+	manifest, _, _ = bytes.Cut(manifest, []byte("\n\n"))
+	return strings.Split(strings.TrimSuffix(string(manifest), "\n"), "\n")
+}
+
+// TestManifestOrderIndependentOfWorkers checks that the manifest lists files
+// in path order, whatever order workers finish in. Files are written in
+// arrival order with -sort none, so only the manifest is compared.
+func TestManifestOrderIndependentOfWorkers(t *testing.T) {
+	dir := writeTestTree(t)
+
+	var manifests [][]string
+	for _, workers := range []string{"1", "8"} {
+		output := filepath.Join(t.TempDir(), "out.txt")
+		runSinglegen(t, "-dir", dir, "-output", output, "-manifest", "-tree-hash", "-sort", "none", "-workers", workers)
+
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifests = append(manifests, manifestLines(t, data))
+	}
+
+	if !slices.Equal(manifests[0], manifests[1]) {
+		t.Fatalf("manifest with 1 worker differs from manifest with 8 workers\n1 worker:\n%s\n8 workers:\n%s",
+			strings.Join(manifests[0], "\n"), strings.Join(manifests[1], "\n"))
+	}
+
+	paths := make([]string, len(manifests[0]))
+	for i, line := range manifests[0] {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("malformed manifest line %q", line)
+		}
+		paths[i] = fields[2]
+	}
+	if len(paths) < 40 || !slices.IsSorted(paths) {
+		t.Errorf("manifest paths are not all files in sorted order: %v", paths)
+	}
+}
+
+// TestManifestAndTreeHashIgnoreLeafOrder checks the manifest and tree hash
+// directly against reordered leaves whose paths use the OS separator
+func TestManifestAndTreeHashIgnoreLeafOrder(t *testing.T) {
+	leaves := []treeLeaf{
+		{path: "b.txt", hash: "2"},
+		{path: filepath.Join("a", "z.txt"), hash: "1"},
+		{path: "a-b.txt", hash: "3"},
+		{path: filepath.Join("a", "b", "c.txt"), hash: "4"},
+	}
+	reversed := slices.Clone(leaves)
+	slices.Reverse(reversed)
+
+	var first, second bytes.Buffer
+	if err := writeManifest(&first, leaves); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManifest(&second, reversed); err != nil {
+		t.Fatal(err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("manifest depends on leaf order:\n%s\n%s", first.String(), second.String())
+	}
+	want := "\n# Manifest:\n# 3  a-b.txt\n# 4  a/b/c.txt\n# 1  a/z.txt\n# 2  b.txt\n"
+	if first.String() != want {
+		t.Errorf("manifest = %q, want %q", first.String(), want)
+	}
+
+	if treeHash(leaves) != treeHash(reversed) {
+		t.Error("tree hash depends on leaf order")
+	}
+}
+
+// TestManifestKeepsExactHashes checks that -hash-ignore-whitespace changes
+// only the tree hash, so manifest lines still verify with sha256sum
+func TestManifestKeepsExactHashes(t *testing.T) {
+	dir := t.TempDir()
+	content := "package main\n\nfunc main()  {\n\treturn\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "f1.go"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	trees := make(map[string]string)
+	for _, flag := range []string{"-hash-ignore-whitespace=false", "-hash-ignore-whitespace"} {
+		output := filepath.Join(t.TempDir(), "out.txt")
+		runSinglegen(t, "-dir", dir, "-output", output, "-manifest", "-tree-hash", flag)
+
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		want := []string{"# " + hex.EncodeToString(sum[:]) + "  f1.go"}
+		if got := manifestLines(t, data); !slices.Equal(got, want) {
+			t.Errorf("manifest with %s = %q, want %q", flag, got, want)
+		}
+
+		_, footer, _ := bytes.Cut(data, []byte("# Tree Hash: "))
+		trees[flag] = string(footer)
+	}
+
+	if trees["-hash-ignore-whitespace"] == trees["-hash-ignore-whitespace=false"] {
+		t.Error("-hash-ignore-whitespace did not change the tree hash")
+	}
+}
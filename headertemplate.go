@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+)
+
+// headerFields are the values available to -header-template and
+// -header-template-for, e.g. '### {{.Label}}: {{.Path}} ({{.Size}} bytes)'.
+// ModTime is empty under -deterministic, and SyntaxError unless
+// -validate-syntax found one.
+type headerFields struct {
+	Label       string
+	Path        string
+	Submodule   string
+	Language    string
+	Size        int64
+	ModTime     string
+	SyntaxError string
+}
+
+// parseHeaderTemplate compiles a header template, turning '\n' into a line
+// break. Templates are executed once against sample values so references to
+// unknown fields fail at startup rather than on the first file.
+func parseHeaderTemplate(name, text string) (*template.Template, error) {
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header template %q: %v", text, err)
+	}
+
+	sample := headerFields{Label: "File", Path: "dir/file.go", Language: "go", Size: 1, ModTime: "2006-01-02 15:04:05"}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, fmt.Errorf("invalid header template %q: %v", text, err)
+	}
+	return tmpl, nil
+}
+
+// headerTemplateRule applies a template to files matching an include-style
+// glob
+type headerTemplateRule struct {
+	pattern *includePattern
+	tmpl    *template.Template
+}
+
+// headerTemplateList is a repeatable list of 'glob=template' rules. The first
+// rule whose glob matches a file decides its header.
+type headerTemplateList []headerTemplateRule
+
+func (l *headerTemplateList) String() string {
+	return ""
+}
+
+func (l *headerTemplateList) Set(value string) error {
+	glob, text, found := strings.Cut(value, "=")
+	if !found {
+		return fmt.Errorf("expected glob=template, got %q", value)
+	}
+
+	pattern, err := parseIncludePattern(strings.TrimSpace(glob))
+	if err != nil {
+		return err
+	}
+	tmpl, err := parseHeaderTemplate(pattern.glob, text)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, headerTemplateRule{pattern: pattern, tmpl: tmpl})
+	return nil
+}
+
+// headerTemplateFor returns the template for the file at relPath, or nil if
+// neither a per-pattern nor a global template applies
+func headerTemplateFor(relPath string, opts *Options) *template.Template {
+	for _, rule := range opts.headerTemplates {
+		if rule.pattern.matches(relPath) {
+			return rule.tmpl
+		}
+	}
+	return opts.headerTemplate
+}
+
+// renderHeader executes tmpl for entry. A non-empty result always ends with
+// a line break.
+func renderHeader(tmpl *template.Template, entry *FileEntry, opts *Options) (string, error) {
+	fields := headerFields{
+		Label:       fileLabel(entry),
+		Path:        displayPath(entry, opts),
+		Submodule:   entry.submodule,
+		Language:    detectLanguage(entry.relPath),
+		Size:        entry.info.Size(),
+		SyntaxError: entry.syntaxError,
+	}
+	if !opts.deterministic {
+		fields.ModTime = entry.info.ModTime().Format("2006-01-02 15:04:05")
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, fields); err != nil {
+		return "", err
+	}
+	text := b.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	return text, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// ignoreRule is a single pattern line from an ignore file, compiled on its own
+// so that matches can be attributed to it
+type ignoreRule struct {
+	source  string
+	lineNo  int
+	line    string
+	matcher *gitignore.GitIgnore
+	hits    int
+}
+
+// IgnoreAudit tracks which loaded ignore patterns matched at least one path
+type IgnoreAudit struct {
+	rules []*ignoreRule
+	mu    sync.Mutex
+}
+
+// enableAudit compiles every pattern from the loaded ignore files and the
+// named bundled templates individually so shouldIgnore can record which of
+// them match. Nested .gitignore files are added as the walk loads them.
+func (il *IgnoreList) enableAudit(templateNames []string) error {
+	audit := &IgnoreAudit{}
+
+	for _, source := range il.sources {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return fmt.Errorf("error loading %s for audit: %v", source, err)
+		}
+		audit.addSource(source, "", data)
+	}
+
+	for _, name := range templateNames {
+		file := gitignoreTemplateFile(name)
+		data, err := gitignoreTemplates.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error loading %s for audit: %v", file, err)
+		}
+		audit.addSource(file, "", data)
+	}
+
+	il.audit = audit
+	return nil
+}
+
+// addSource adds the patterns of one ignore file to the audit. dir is the
+// slash-separated directory of a nested .gitignore relative to the scanned
+// directory, so its patterns match the same paths they do in the walk, or
+// empty for a file whose patterns apply from the root.
+func (a *IgnoreAudit) addSource(source, dir string, data []byte) {
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		// Negated patterns are audited by what they would re-include
+		pattern := strings.TrimPrefix(trimmed, "!")
+		if dir != "" {
+			pattern = rootRelativePattern(dir, pattern)
+		}
+		a.rules = append(a.rules, &ignoreRule{
+			source:  source,
+			lineNo:  i + 1,
+			line:    trimmed,
+			matcher: gitignore.CompileIgnoreLines(pattern),
+		})
+	}
+}
+
+func (a *IgnoreAudit) record(path string) {
+	for _, rule := range a.rules {
+		if rule.matcher.MatchesPath(path) {
+			a.mu.Lock()
+			rule.hits++
+			a.mu.Unlock()
+		}
+	}
+}
+
+func (a *IgnoreAudit) report(w io.Writer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var unused []*ignoreRule
+	for _, rule := range a.rules {
+		if rule.hits == 0 {
+			unused = append(unused, rule)
+		}
+	}
+
+	if len(unused) == 0 {
+		fmt.Fprintf(w, "Ignore audit: all %d patterns matched at least one path\n", len(a.rules))
+		return
+	}
+
+	fmt.Fprintf(w, "Ignore audit: %d of %d patterns never matched:\n", len(unused), len(a.rules))
+	for _, rule := range unused {
+		fmt.Fprintf(w, "  %s:%d: %s\n", rule.source, rule.lineNo, rule.line)
+	}
+}
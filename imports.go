@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// lexState tracks multi-line constructs that can hide import-like text
+type lexState int
+
+const (
+	lexCode lexState = iota
+	lexBlockComment
+	lexBacktick
+	lexTripleDouble
+	lexTripleSingle
+)
+
+// advanceLexState scans one line and returns the state at its end. It only
+// needs to be precise enough to know whether the next line starts in code.
+func advanceLexState(lang, line string, state lexState) lexState {
+	python := lang == "python"
+	for i := 0; i < len(line); i++ {
+		switch state {
+		case lexBlockComment:
+			if strings.HasPrefix(line[i:], "*/") {
+				state = lexCode
+				i++
+			}
+			continue
+		case lexBacktick:
+			if line[i] == '\\' && lang != "go" {
+				i++
+			} else if line[i] == '`' {
+				state = lexCode
+			}
+			continue
+		case lexTripleDouble, lexTripleSingle:
+			quote := `"""`
+			if state == lexTripleSingle {
+				quote = "'''"
+			}
+			if line[i] == '\\' {
+				i++
+			} else if strings.HasPrefix(line[i:], quote) {
+				state = lexCode
+				i += 2
+			}
+			continue
+		}
+
+		switch c := line[i]; {
+		case python && c == '#':
+			return state
+		case python && strings.HasPrefix(line[i:], `"""`):
+			state = lexTripleDouble
+			i += 2
+		case python && strings.HasPrefix(line[i:], "'''"):
+			state = lexTripleSingle
+			i += 2
+		case !python && strings.HasPrefix(line[i:], "//"):
+			return state
+		case !python && strings.HasPrefix(line[i:], "/*"):
+			state = lexBlockComment
+			i++
+		case !python && c == '`':
+			state = lexBacktick
+		case c == '"' || c == '\'':
+			// Skip a single-line string literal
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		}
+	}
+	return state
+}
+
+var (
+	goImportLine     = regexp.MustCompile(`^\s*import\s+(\w+\s+|\.\s+|_\s+)?"[^"]*"\s*(//.*)?$`)
+	goImportBlock    = regexp.MustCompile(`^\s*import\s*\(\s*(//.*)?$`)
+	pyImportLine     = regexp.MustCompile(`^\s*(import\s+[\w.]|from\s+[\w.]+\s+import\b)`)
+	jsImportStart    = regexp.MustCompile(`^\s*import[\s{*]`)
+	jsImportEnd      = regexp.MustCompile(`(^\s*import\s*['"]|\bfrom\s*['"][^'"]*['"])`)
+	jsRequireLine    = regexp.MustCompile(`^\s*((const|let|var)\s+[^=]+=\s*)?require\(\s*['"][^'"]*['"]\s*\)[\w.]*\s*;?\s*$`)
+	jsImportTypeOnly = regexp.MustCompile(`^\s*import\s+type\s`)
+)
+
+// stripImports removes import statements from content for the languages it
+// understands and returns other content unchanged
+func stripImports(lang string, content []byte) []byte {
+	switch lang {
+	case "go", "python", "javascript", "typescript":
+	default:
+		return content
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	out := make([]byte, 0, len(content))
+	state := lexCode
+
+	for i := 0; i < len(lines); i++ {
+		line := string(lines[i])
+		if state == lexCode {
+			if end := importEnd(lang, lines, i); end >= i {
+				i = end
+				continue
+			}
+		}
+		state = advanceLexState(lang, line, state)
+		out = append(out, lines[i]...)
+	}
+	return out
+}
+
+// importEnd returns the index of the last line of an import statement that
+// starts at line i, or -1 if line i does not start one
+func importEnd(lang string, lines [][]byte, i int) int {
+	line := strings.TrimRight(string(lines[i]), "\r\n")
+
+	switch lang {
+	case "go":
+		if goImportLine.MatchString(line) {
+			return i
+		}
+		if goImportBlock.MatchString(line) {
+			return scanUntil(lines, i, func(l string) bool { return strings.TrimSpace(l) == ")" })
+		}
+	case "python":
+		if !pyImportLine.MatchString(line) {
+			return -1
+		}
+		if strings.Contains(line, "(") && !strings.Contains(line, ")") {
+			return scanUntil(lines, i, func(l string) bool { return strings.Contains(l, ")") })
+		}
+		for i < len(lines)-1 && strings.HasSuffix(strings.TrimRight(string(lines[i]), "\r\n"), "\\") {
+			i++
+		}
+		return i
+	case "javascript", "typescript":
+		if jsRequireLine.MatchString(line) {
+			return i
+		}
+		if jsImportStart.MatchString(line) || jsImportTypeOnly.MatchString(line) {
+			if jsImportEnd.MatchString(line) {
+				return i
+			}
+			return scanUntil(lines, i, func(l string) bool { return jsImportEnd.MatchString(l) })
+		}
+	}
+	return -1
+}
+
+// scanUntil returns the index of the first line after i for which done
+// reports true, or -1 if the statement never terminates
+func scanUntil(lines [][]byte, i int, done func(string) bool) int {
+	for j := i + 1; j < len(lines); j++ {
+		if done(string(lines[j])) {
+			return j
+		}
+	}
+	return -1
+}
+
+// stripImportsTransform removes import statements based on each file's
+// detected language
+func stripImportsTransform(entry *FileEntry, content []byte) ([]byte, error) {
+	return stripImports(detectLanguage(entry.relPath), content), nil
+}
+
+var (
+	goImportPath   = regexp.MustCompile(`"([^"]*)"`)
+	jsImportSpec   = regexp.MustCompile(`(?:\bfrom|^\s*import|\brequire\()\s*['"]([^'"]+)['"]`)
+	pyFromImport   = regexp.MustCompile(`^\s*from\s+([\w.]+)\s+import\s+(.*)$`)
+	pyPlainImport  = regexp.MustCompile(`^\s*import\s+(.*)$`)
+	pyImportFiller = strings.NewReplacer("\\\n", " ", "(", " ", ")", " ", "\n", " ")
+)
+
+// extractImports returns the module specifiers named by the import
+// statements in content, as written: Go import paths, JavaScript and
+// TypeScript module specifiers, and dotted Python module names. For Python's
+// "from X import a" both X and X.a are returned since a may be a submodule.
+func extractImports(lang string, content []byte) []string {
+	switch lang {
+	case "go", "python", "javascript", "typescript":
+	default:
+		return nil
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	var specs []string
+	state := lexCode
+
+	for i := 0; i < len(lines); i++ {
+		if state == lexCode {
+			if end := importEnd(lang, lines, i); end >= i {
+				statement := string(bytes.Join(lines[i:end+1], nil))
+				specs = append(specs, importSpecs(lang, statement)...)
+				i = end
+				continue
+			}
+		}
+		state = advanceLexState(lang, string(lines[i]), state)
+	}
+	return specs
+}
+
+// importSpecs extracts the specifiers from a single import statement
+func importSpecs(lang, statement string) []string {
+	var specs []string
+	switch lang {
+	case "go":
+		for _, m := range goImportPath.FindAllStringSubmatch(statement, -1) {
+			specs = append(specs, m[1])
+		}
+	case "javascript", "typescript":
+		for _, m := range jsImportSpec.FindAllStringSubmatch(statement, -1) {
+			specs = append(specs, m[1])
+		}
+	case "python":
+		statement = strings.TrimSpace(pyImportFiller.Replace(statement))
+		if m := pyFromImport.FindStringSubmatch(statement); m != nil {
+			module := m[1]
+			if module != strings.Repeat(".", len(module)) {
+				specs = append(specs, module)
+				module += "."
+			}
+			for _, name := range pyImportNames(m[2]) {
+				specs = append(specs, module+name)
+			}
+		} else if m := pyPlainImport.FindStringSubmatch(statement); m != nil {
+			specs = append(specs, pyImportNames(m[1])...)
+		}
+	}
+	return specs
+}
+
+// pyImportNames splits a Python import list such as "a.b as c, d" into the
+// imported names, dropping aliases, comments and wildcards
+func pyImportNames(list string) []string {
+	list, _, _ = strings.Cut(list, "#")
+
+	var names []string
+	for _, part := range strings.Split(list, ",") {
+		fields := strings.Fields(part)
+		if len(fields) > 0 && fields[0] != "*" {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// includePattern is a glob from -include, using gitignore syntax, with an
+// optional depth constraint such as '*.go@depth<=2'
+type includePattern struct {
+	glob    string
+	matcher *gitignore.GitIgnore
+	depthOp string
+	depth   int
+}
+
+var depthSuffix = regexp.MustCompile(`@depth(<=|>=|==|<|>|=)(\d+)$`)
+
+func parseIncludePattern(value string) (*includePattern, error) {
+	p := &includePattern{glob: value}
+
+	if m := depthSuffix.FindStringSubmatch(value); m != nil {
+		p.glob = strings.TrimSuffix(value, m[0])
+		p.depthOp = m[1]
+		p.depth, _ = strconv.Atoi(m[2])
+	} else if strings.Contains(value, "@depth") {
+		return nil, fmt.Errorf("invalid depth constraint in %q (expected e.g. @depth<=2)", value)
+	}
+
+	if p.glob == "" {
+		return nil, fmt.Errorf("empty include pattern in %q", value)
+	}
+	p.matcher = gitignore.CompileIgnoreLines(p.glob)
+	return p, nil
+}
+
+// matchDepth returns the depth of the shortest leading part of relPath the
+// glob matches, or 0 if it matches none; paths at the top of the scanned
+// directory have depth 1. A directory pattern such as 'vendor'
+// is thus measured at the vendor directory, not at the files below it. A
+// trailing separator marks relPath as a directory.
+func (p *includePattern) matchDepth(relPath string) int {
+	slashed := filepath.ToSlash(relPath)
+	isDir := strings.HasSuffix(slashed, "/")
+	parts := strings.Split(strings.TrimSuffix(slashed, "/"), "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		if i < len(parts)-1 || isDir {
+			prefix += "/"
+		}
+		if p.matcher.MatchesPath(prefix) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (p *includePattern) matches(relPath string) bool {
+	if p.depthOp == "" {
+		return p.matcher.MatchesPath(relPath)
+	}
+
+	depth := p.matchDepth(relPath)
+	if depth == 0 {
+		return false
+	}
+	switch p.depthOp {
+	case "<=":
+		return depth <= p.depth
+	case "<":
+		return depth < p.depth
+	case ">=":
+		return depth >= p.depth
+	case ">":
+		return depth > p.depth
+	case "=", "==":
+		return depth == p.depth
+	}
+	return true
+}
+
+// includeList is a repeatable, comma-separated list of include patterns
+type includeList []*includePattern
+
+func (l *includeList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	globs := make([]string, len(*l))
+	for i, p := range *l {
+		globs[i] = p.glob
+	}
+	return strings.Join(globs, ",")
+}
+
+func (l *includeList) Set(value string) error {
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+
+		p, err := parseIncludePattern(part)
+		if err != nil {
+			return err
+		}
+		*l = append(*l, p)
+	}
+	return nil
+}
+
+// match returns the index of the first pattern matching relPath, or -1
+func (l includeList) match(relPath string) int {
+	for i, p := range l {
+		if p.matches(relPath) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// languageByExt maps lowercase file extensions to language names
+var languageByExt = map[string]string{
+	".go":    "go",
+	".py":    "python",
+	".pyi":   "python",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".jsx":   "javascript",
+	".ts":    "typescript",
+	".tsx":   "typescript",
+	".mts":   "typescript",
+	".cts":   "typescript",
+	".rs":    "rust",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".cxx":   "cpp",
+	".hpp":   "cpp",
+	".hh":    "cpp",
+	".java":  "java",
+	".kt":    "kotlin",
+	".kts":   "kotlin",
+	".swift": "swift",
+	".cs":    "csharp",
+	".rb":    "ruby",
+	".php":   "php",
+	".lua":   "lua",
+	".sh":    "shell",
+	".bash":  "shell",
+	".zsh":   "shell",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".htm":   "html",
+	".css":   "css",
+	".scss":  "scss",
+	".xml":   "xml",
+	".json":  "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".ini":   "ini",
+	".md":    "markdown",
+	".proto": "protobuf",
+	".vim":   "vim",
+}
+
+// languageByName maps well-known extensionless file names to language names
+var languageByName = map[string]string{
+	"makefile":   "makefile",
+	"dockerfile": "dockerfile",
+	"gemfile":    "ruby",
+	"rakefile":   "ruby",
+}
+
+// detectLanguage guesses a file's language from its name, returning an empty
+// string when it is not recognized
+func detectLanguage(path string) string {
+	base := filepath.Base(path)
+	if lang, ok := languageByName[strings.ToLower(base)]; ok {
+		return lang
+	}
+	return languageByExt[strings.ToLower(filepath.Ext(base))]
+}
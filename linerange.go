@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// lineRange is an inclusive, 1-based range of lines
+type lineRange struct {
+	start, end int
+}
+
+// lineRanges holds the -range excerpts by slash-separated relative path and
+// tracks which of them matched a file, so unused ones can be reported
+type lineRanges struct {
+	byPath map[string]lineRange
+
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func (r *lineRanges) String() string {
+	if r == nil {
+		return ""
+	}
+	var specs []string
+	for _, p := range slices.Sorted(maps.Keys(r.byPath)) {
+		rng := r.byPath[p]
+		specs = append(specs, fmt.Sprintf("%s:%d-%d", p, rng.start, rng.end))
+	}
+	return strings.Join(specs, ",")
+}
+
+// Set parses 'path:START-END'. The path is taken up to the last colon so
+// Windows drive letters and colons in names survive.
+func (r *lineRanges) Set(value string) error {
+	i := strings.LastIndex(value, ":")
+	if i <= 0 {
+		return fmt.Errorf("expected path:START-END, got %q", value)
+	}
+	file, spec := value[:i], value[i+1:]
+
+	lo, hi, found := strings.Cut(spec, "-")
+	start, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	end, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if !found || err1 != nil || err2 != nil || start < 1 || end < start {
+		return fmt.Errorf("invalid line range %q in %q (expected START-END with 1 <= START <= END)", spec, value)
+	}
+
+	key := path.Clean(filepath.ToSlash(file))
+	if r.byPath == nil {
+		r.byPath = make(map[string]lineRange)
+		r.used = make(map[string]bool)
+	}
+	if _, ok := r.byPath[key]; ok {
+		return fmt.Errorf("more than one -range for %s", file)
+	}
+	r.byPath[key] = lineRange{start, end}
+	return nil
+}
+
+// markUsed records that the file at relPath was processed, whether its
+// excerpt was cut now or came from the cache
+func (r *lineRanges) markUsed(relPath string) {
+	key := filepath.ToSlash(relPath)
+	if _, ok := r.byPath[key]; !ok {
+		return
+	}
+	r.mu.Lock()
+	r.used[key] = true
+	r.mu.Unlock()
+}
+
+// unmatched returns the range paths that no processed file matched, sorted
+func (r *lineRanges) unmatched() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var paths []string
+	for p := range r.byPath {
+		if !r.used[p] {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// transform replaces the content of files with a -range by the requested
+// lines, preceded by a marker giving the range and the file's line count
+func (r *lineRanges) transform(entry *FileEntry, content []byte) ([]byte, error) {
+	rng, ok := r.byPath[filepath.ToSlash(entry.relPath)]
+	if !ok {
+		return content, nil
+	}
+
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	if rng.start > lines {
+		return []byte(fmt.Sprintf("### [excerpt: lines %d-%d requested, file has %d lines]\n", rng.start, rng.end, lines)), nil
+	}
+
+	end := min(rng.end, lines)
+	excerpt := content[lineStart(content, rng.start-1):lineStart(content, end)]
+	out := []byte(fmt.Sprintf("### [excerpt: lines %d-%d of %d]\n", rng.start, end, lines))
+	return append(out, excerpt...), nil
+}
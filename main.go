@@ -1,12 +1,16 @@
 package main
 
 import (
-	"flag"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -17,28 +21,129 @@ import (
 // FileEntry represents a file to be processed with its metadata
 type FileEntry struct {
 	path    string
+	relPath string
 	info    os.FileInfo
 	content []byte
+	hash    string
 	err     error
+	release func()
+
+	// syntaxError describes why the file failed to parse under
+	// -validate-syntax
+	syntaxError string
+
+	// changeHash replaces hash in the tree hash when -hash-ignore-whitespace
+	// is set; hash itself always covers the exact content, since duplicates
+	// are detected and the manifest is written with it
+	changeHash string
+
+	// submodule is the path of the git submodule the file belongs to, if any
+	submodule string
+
+	// secrets lists the secrets detected in the content, if scanning is on
+	secrets []secretFinding
+
+	// imports lists the import specifiers found in the file as read, kept
+	// only when -reachable-from needs them to build the import graph
+	imports []string
+
+	// encoding is the charset the file was transcoded from, if any
+	encoding *encodingGuess
+
+	// warnings are reported when the entry is written rather than when it is
+	// processed, so they appear in output order
+	warnings []string
+
+	// omitReason is set when the file is listed with its header but its
+	// content is replaced by a note
+	omitReason string
+
+	// skipReason is set when the file was read but deliberately left out,
+	// with skipDetail explaining the decision for -verbose
+	skipReason string
+	skipDetail string
+
+	// citeIndex is the file's 1-based citation number under -cite-index
+	citeIndex int
+
+	// includeIndex is the position of the first -include pattern the file
+	// matched, or -1 without -include
+	includeIndex int
+}
+
+// Reasons a file's content can be omitted from the output
+const (
+	omitBinary   = "binary"
+	omitTooLarge = "too large"
+)
+
+// omitNote returns the line written in place of an omitted file's content
+func omitNote(entry *FileEntry) string {
+	if entry.omitReason == omitTooLarge {
+		return fmt.Sprintf("### [skipped: %d bytes exceeds limit]\n", entry.info.Size())
+	}
+	return fmt.Sprintf("### [%s file skipped]\n", entry.omitReason)
+}
+
+// Reasons a file can be skipped after it has been read
+const (
+	skipMinified      = "minified"
+	skipDuplicatePath = "duplicate path"
+	skipUnreachable   = "not reachable"
+	skipNotRecent     = "not recent"
+	skipLockfileLike  = "lockfile-like"
+	skipOverBudget    = "over token budget"
+	skipInFlux        = "recently modified"
+)
+
+// emptyHash is the content hash of files whose content is omitted
+var emptyHash = func() string {
+	sum := sha256.Sum256(nil)
+	return hex.EncodeToString(sum[:])
+}()
+
+// maxPooledBuffer caps the size of buffers returned to the pool so a single
+// large file doesn't pin its memory for the rest of the run
+const maxPooledBuffer = 1 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
 }
 
 type IgnoreList struct {
 	gitIgnore    *gitignore.GitIgnore
 	singleIgnore *gitignore.GitIgnore
+	templates    *gitignore.GitIgnore
+	globalIgnore *gitignore.GitIgnore
+	sources      []string
+	audit        *IgnoreAudit
 	mu           sync.RWMutex
+
+	// root is the directory the list applies to. rootLines are the patterns
+	// of its .gitignore, and nested maps each subdirectory with a .gitignore
+	// of its own to a matcher for everything below it; see
+	// loadNestedGitignore.
+	root        string
+	rootLines   []string
+	nested      map[string]*gitignore.GitIgnore
+	nestedLines map[string][]string
+	gitDisabled bool
 }
 
 func NewIgnoreList(dir string) (*IgnoreList, error) {
-	il := &IgnoreList{}
+	il := &IgnoreList{root: dir}
 
-	// Load .gitignore
+	// Load .gitignore; those in subdirectories are loaded as the walk
+	// reaches them
 	gitIgnorePath := filepath.Join(dir, ".gitignore")
 	if _, err := os.Stat(gitIgnorePath); err == nil {
-		gitIgnore, err := gitignore.CompileIgnoreFile(gitIgnorePath)
+		lines, err := gitignoreLines(gitIgnorePath, "")
 		if err != nil {
 			return nil, fmt.Errorf("error loading .gitignore: %v", err)
 		}
-		il.gitIgnore = gitIgnore
+		il.gitIgnore = gitignore.CompileIgnoreLines(lines...)
+		il.rootLines = lines
+		il.sources = append(il.sources, gitIgnorePath)
 	}
 
 	// Load .singlegenignore
@@ -49,11 +154,75 @@ func NewIgnoreList(dir string) (*IgnoreList, error) {
 			return nil, fmt.Errorf("error loading .singlegenignore: %v", err)
 		}
 		il.singleIgnore = singleIgnore
+		il.sources = append(il.sources, singleIgnorePath)
 	}
 
 	return il, nil
 }
 
+// loadIgnoreLists builds the ignore rules of a run: the scanned directory's
+// .gitignore and .singlegenignore, the bundled templates, git's global
+// excludes under -git-global-ignore and, under -recurse-submodules, each
+// submodule's own list, which is stored in opts.submodules. Problems are
+// reported as warnings.
+func loadIgnoreLists(opts *Options) *IgnoreList {
+	ignoreList, err := NewIgnoreList(opts.dirPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		ignoreList = &IgnoreList{root: opts.dirPath}
+	}
+
+	ignoreList.templates = opts.templateIgnore
+
+	var globalPath string
+	var globalIgnore *gitignore.GitIgnore
+	if opts.gitGlobalIgnore {
+		globalPath, globalIgnore, err = loadGlobalExcludes(opts.dirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: error loading global excludes file: %v\n", err)
+		} else if globalIgnore == nil {
+			fmt.Fprintln(os.Stderr, "Warning: no global git excludes file is configured")
+		} else {
+			ignoreList.addGlobalExcludes(globalPath, globalIgnore)
+		}
+	}
+
+	if opts.recurseSubmodules {
+		submodules, uninitialized, err := loadSubmodules(opts.dirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: error loading submodules: %v\n", err)
+		}
+		for _, path := range uninitialized {
+			fmt.Fprintf(os.Stderr, "Warning: submodule %s is not initialized; run 'git submodule update --init' to include it\n", path)
+		}
+		for _, sub := range submodules {
+			sub.ignoreList.templates = opts.templateIgnore
+			if globalIgnore != nil {
+				sub.ignoreList.addGlobalExcludes(globalPath, globalIgnore)
+			}
+		}
+		opts.submodules = submodules
+	}
+	return ignoreList
+}
+
+// disableGitIgnore drops the built-in .gitignore matcher when git itself
+// decides which paths are ignored
+func (il *IgnoreList) disableGitIgnore() {
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	il.gitDisabled = true
+	il.nested, il.nestedLines = nil, nil
+	if il.gitIgnore == nil {
+		return
+	}
+	il.gitIgnore = nil
+	il.sources = slices.DeleteFunc(il.sources, func(source string) bool {
+		return filepath.Base(source) == ".gitignore"
+	})
+}
+
 func (il *IgnoreList) shouldIgnore(path string) bool {
 	il.mu.RLock()
 	defer il.mu.RUnlock()
@@ -63,14 +232,19 @@ func (il *IgnoreList) shouldIgnore(path string) bool {
 	case strings.Contains(path, string(filepath.Separator)+".git"+string(filepath.Separator)) ||
 		strings.HasPrefix(path, ".git"+string(filepath.Separator)) ||
 		path == ".git" ||
-		path == ".gitignore" ||
+		filepath.Base(path) == ".gitignore" ||
 		path == ".DS_Store" ||
 		path == ".singlegenignore":
 		return true
 	}
 
-	// Check gitignore patterns
-	if il.gitIgnore != nil && il.gitIgnore.MatchesPath(path) {
+	// Attribute the path to every pattern that matches it when auditing
+	if il.audit != nil {
+		il.audit.record(path)
+	}
+
+	// Check gitignore patterns, including those of nested .gitignore files
+	if gitIgnore := il.gitignoreFor(path); gitIgnore != nil && gitIgnore.MatchesPath(path) {
 		return true
 	}
 
@@ -79,52 +253,282 @@ func (il *IgnoreList) shouldIgnore(path string) bool {
 		return true
 	}
 
+	// Check the user's global excludes
+	if il.globalIgnore != nil && il.globalIgnore.MatchesPath(path) {
+		return true
+	}
+
+	// Check bundled template patterns
+	if il.templates != nil && il.templates.MatchesPath(path) {
+		return true
+	}
+
 	return false
 }
 
-func processFile(path string, info os.FileInfo) (*FileEntry, error) {
+func processFile(path, relPath string, info os.FileInfo, opts *Options) (*FileEntry, error) {
 	if info.IsDir() {
 		return nil, nil
 	}
 
-	file, err := os.Open(path)
+	entry := &FileEntry{
+		path:    path,
+		relPath: relPath,
+		info:    info,
+	}
+
+	// Files modified moments ago may still be mid-save, so they are left for
+	// a later run rather than risking a torn read
+	if opts.skipRecent > 0 && time.Since(info.ModTime()) < opts.skipRecent {
+		entry.skipReason = skipInFlux
+		return entry, nil
+	}
+
+	// Oversized files are listed without ever being opened
+	if opts.maxSize > 0 && info.Size() > opts.maxSize {
+		entry.omitReason = omitTooLarge
+		entry.hash = emptyHash
+		return entry, nil
+	}
+
+	file, err := openFile(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	content, err := io.ReadAll(file)
-	if err != nil {
+	if opts.mmap && info.Size() >= opts.mmapMinSize {
+		// Mapping can fail for special files or on unsupported platforms, in
+		// which case the file is read as usual below
+		entry.content, entry.release, _ = mmapFile(file, info.Size())
+	}
+	mapped := entry.content != nil
+
+	switch {
+	case entry.content != nil:
+		// Mapped; the release function unmaps once the entry is written
+	case opts.readBufferPool:
+		// The buffer backs entry.content, so it may only go back to the pool
+		// once the writer is done with the entry
+		buf := bufferPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		buf.Grow(int(info.Size()) + bytes.MinRead)
+		if _, err := buf.ReadFrom(file); err != nil {
+			bufferPool.Put(buf)
+			return nil, describeLockError(err)
+		}
+		entry.content = buf.Bytes()
+		entry.release = func() {
+			if buf.Cap() <= maxPooledBuffer {
+				bufferPool.Put(buf)
+			}
+		}
+	default:
+		content, err := io.ReadAll(file)
+		if err != nil {
+			return nil, describeLockError(err)
+		}
+		entry.content = content
+	}
+
+	// A file that changed while it was read is as suspect as a recent one
+	if opts.skipRecent > 0 {
+		if after, err := file.Stat(); err == nil && (!after.ModTime().Equal(info.ModTime()) || after.Size() != info.Size()) {
+			if entry.release != nil {
+				entry.release()
+			}
+			entry.content, entry.release = nil, nil
+			entry.skipReason = skipInFlux
+			return entry, nil
+		}
+	}
+
+	// Transcoding can turn UTF-16 and Latin-1 files into text, so only
+	// content it cannot handle counts as binary then
+	if !opts.includeBinary && looksBinary(entry.content) {
+		if _, ok := detectEncoding(entry.content); !opts.transcode || !ok {
+			if entry.release != nil {
+				entry.release()
+			}
+			entry.content, entry.release = nil, nil
+			entry.omitReason = omitBinary
+			entry.hash = emptyHash
+			return entry, nil
+		}
+	}
+
+	if opts.skipMinified && looksMinified(entry.content) {
+		if entry.release != nil {
+			entry.release()
+		}
+		entry.content, entry.release = nil, nil
+		entry.skipReason = skipMinified
+		return entry, nil
+	}
+
+	if opts.skipLockfileLike {
+		if stats := measureLockfile(entry.content); defaultLockfileThresholds.looksLockfileLike(stats) {
+			if entry.release != nil {
+				entry.release()
+			}
+			entry.content, entry.release = nil, nil
+			entry.skipReason = skipLockfileLike
+			entry.skipDetail = stats.String()
+			return entry, nil
+		}
+	}
+
+	// Imports are extracted before transforms such as -strip-imports run
+	if len(opts.reachableFrom) > 0 || opts.sortMode == sortTopo {
+		entry.imports = extractImports(detectLanguage(relPath), entry.content)
+	}
+
+	if err := applyTransforms(entry, opts); err != nil {
+		if entry.release != nil {
+			entry.release()
+		}
 		return nil, err
 	}
 
-	return &FileEntry{
-		path:    path,
-		info:    info,
-		content: content,
-	}, nil
+	hashContent(entry, opts)
+	if opts.validateSyntax {
+		entry.syntaxError = checkSyntax(relPath, entry.content)
+	}
+
+	// Sorted runs hold every entry until the last file is read. A mapping
+	// kept that long kills the run with SIGBUS if another process truncates
+	// the file meanwhile, so the content is copied out and unmapped now;
+	// only streamed runs write straight from the mapping.
+	if mapped && opts.sortOutput {
+		entry.content = bytes.Clone(entry.content)
+		entry.release()
+		entry.release = nil
+	}
+
+	return entry, nil
+}
+
+// previewContent returns the first n lines of content along with the number
+// of lines that were left out
+func previewContent(content []byte, n int) ([]byte, int) {
+	end := 0
+	for i := 0; i < n; i++ {
+		idx := bytes.IndexByte(content[end:], '\n')
+		if idx < 0 {
+			return content, 0
+		}
+		end += idx + 1
+	}
+
+	rest := content[end:]
+	omitted := bytes.Count(rest, []byte("\n"))
+	if len(rest) > 0 && rest[len(rest)-1] != '\n' {
+		omitted++
+	}
+	return content[:end], omitted
 }
 
-func writeFileEntry(outputFile *os.File, entry *FileEntry) error {
-	header := fmt.Sprintf("\n### File: %s\n### Size: %d bytes\n### Last Modified: %s\n\n",
-		entry.path, entry.info.Size(), entry.info.ModTime().Format("2006-01-02 15:04:05"))
+// displayPath returns the path shown for an entry in the output
+func displayPath(entry *FileEntry, opts *Options) string {
+	path := entry.path
+	if opts.dirAlias != "" {
+		path = opts.dirAlias + "/" + filepath.ToSlash(entry.relPath)
+	} else if opts.relativePaths {
+		path = filepath.ToSlash(entry.relPath)
+	} else if opts.posixPaths {
+		path = filepath.ToSlash(path)
+	}
+	if opts.lowercasePaths {
+		path = strings.ToLower(path)
+	}
+	return path
+}
 
-	if _, err := outputFile.WriteString(header); err != nil {
+// pathSource returns the directory displayPath puts in front of relative
+// paths, or "" when it writes them bare
+func pathSource(opts *Options) string {
+	switch {
+	case opts.dirAlias != "":
+		return opts.dirAlias
+	case opts.relativePaths:
+		return ""
+	}
+	return opts.dirPath
+}
+
+func writeFileEntry(out io.Writer, entry *FileEntry, opts *Options) error {
+	header := "\n"
+	if opts.separatorNonce != "" {
+		header += separatorLine(opts.separatorNonce, "START")
+	}
+	if tmpl := headerTemplateFor(entry.relPath, opts); tmpl != nil {
+		text, err := renderHeader(tmpl, entry, opts)
+		if err != nil {
+			return err
+		}
+		if text != "" {
+			header += text + "\n"
+		}
+	} else if syntax, ok := headerSyntax(entry.relPath, opts); ok {
+		line := func(format string, args ...any) {
+			header += syntax.prefix + fmt.Sprintf(format, args...) + syntax.suffix + "\n"
+		}
+		line("%s: %s", fileLabel(entry), displayPath(entry, opts))
+		if entry.submodule != "" {
+			line("Submodule: %s", entry.submodule)
+		}
+		line("Size: %d bytes", entry.info.Size())
+		if entry.syntaxError != "" {
+			line("Syntax Error: %s", entry.syntaxError)
+		}
+		if !opts.deterministic {
+			line("Last Modified: %s", entry.info.ModTime().Format("2006-01-02 15:04:05"))
+		}
+		header += "\n"
+	}
+
+	if _, err := io.WriteString(out, header); err != nil {
 		return err
 	}
 
-	if _, err := outputFile.Write(entry.content); err != nil {
+	content, omitted := entryBody(entry, opts)
+
+	if _, err := out.Write(content); err != nil {
 		return err
 	}
 
-	if _, err := outputFile.WriteString("\n"); err != nil {
+	if omitted > 0 {
+		if _, err := fmt.Fprintf(out, "### [preview: %d more lines not shown]\n", omitted); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.WriteString(out, "\n"); err != nil {
 		return err
 	}
 
+	if opts.separatorNonce != "" {
+		if _, err := io.WriteString(out, separatorLine(opts.separatorNonce, "END")); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func worker(jobs <-chan string, results chan<- *FileEntry, ignoreList *IgnoreList, dirPath string, wg *sync.WaitGroup) {
+// matchesPathFilters applies the regex path filters, with excludes taking
+// precedence over includes
+func matchesPathFilters(relPath string, opts *Options) bool {
+	if opts.pathExcludeRe.matchAny(relPath) {
+		return false
+	}
+	if len(opts.pathIncludeRe) > 0 && !opts.pathIncludeRe.matchAny(relPath) {
+		return false
+	}
+	return true
+}
+
+func worker(jobs <-chan string, results chan<- *FileEntry, ignoreList *IgnoreList, cache *fileCache, opts *Options, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for path := range jobs {
@@ -134,55 +538,250 @@ func worker(jobs <-chan string, results chan<- *FileEntry, ignoreList *IgnoreLis
 			continue
 		}
 
-		relPath, err := filepath.Rel(dirPath, path)
+		relPath, err := filepath.Rel(opts.dirPath, path)
 		if err != nil {
 			results <- &FileEntry{path: path, err: err}
 			continue
 		}
 
-		if ignoreList.shouldIgnore(relPath) {
+		// Files inside a submodule follow that submodule's ignore rules
+		sub, subPath := findSubmodule(opts.submodules, relPath)
+		if sub != nil {
+			if sub.ignoreList.shouldIgnore(subPath) {
+				continue
+			}
+		} else if ignoreList.shouldIgnore(relPath) {
+			continue
+		}
+
+		if !info.IsDir() && !matchesPathFilters(filepath.ToSlash(relPath), opts) {
+			continue
+		}
+
+		if !info.IsDir() && opts.excludes.match(relPath) >= 0 {
 			continue
 		}
 
-		entry, err := processFile(path, info)
+		includeIndex := -1
+		if !info.IsDir() && len(opts.includes) > 0 {
+			if includeIndex = opts.includes.match(relPath); includeIndex < 0 {
+				continue
+			}
+		}
+
+		entry, err := processWithTimeout(path, opts, func() (*FileEntry, error) {
+			if cache != nil {
+				return cache.process(path, relPath, info, opts)
+			}
+			return processFile(path, relPath, info, opts)
+		})
 		if err != nil {
 			results <- &FileEntry{path: path, err: err}
 			continue
 		}
 
 		if entry != nil {
+			if sub != nil {
+				entry.submodule = sub.path
+			}
+			entry.includeIndex = includeIndex
+			opts.lineRanges.markUsed(relPath)
 			results <- entry
 		}
 	}
 }
 
 func main() {
-	// Parse command line arguments
-	dirPath := flag.String("dir", ".", "Directory to scan (default: current working directory)")
-	outputPath := flag.String("output", "combined_output.txt", "Output file path")
-	workers := flag.Int("workers", runtime.NumCPU(), "Number of worker goroutines")
-	flag.Parse()
+	started := time.Now()
 
-	// Create output file
-	outputFile, err := os.Create(*outputPath)
+	// Parse command line arguments
+	opts, err := parseOptions()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+
+	if opts.listGitignoreTemplates {
+		for _, name := range gitignoreTemplateNames() {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if opts.unpackPath != "" {
+		if err := unpack(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error unpacking: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.watch {
+		if err := watch(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error watching: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.outputPath == "" && !opts.clipboard && opts.postTo == "" && !opts.validate && !opts.tokenReport {
+		fmt.Fprintln(os.Stderr, "Error: no output selected; set -output, -clipboard or -post-to")
 		os.Exit(1)
 	}
-	defer outputFile.Close()
+
+	// Remember the last recorded tree hash before the output is overwritten
+	var lastTreeHash string
+	if opts.onChangeExec != "" {
+		lastTreeHash = previousTreeHash(opts.outputPath)
+	}
+
+	// Create output file and collect every destination into a single writer
+	var sinks []io.Writer
+	var compressor io.WriteCloser
+	if opts.outputPath != "" {
+		outputFile, err := os.Create(opts.outputPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer outputFile.Close()
+
+		var sink io.Writer = outputFile
+		if opts.compress != compressNone {
+			compressor, err = newCompressor(outputFile, opts)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error creating compressor: %v\n", err)
+				os.Exit(1)
+			}
+			sink = compressor
+		}
+		sinks = append(sinks, sink)
+	}
+
+	var clipboardBuf bytes.Buffer
+	if opts.clipboard {
+		sinks = append(sinks, &clipboardBuf)
+	}
+
+	var poster *httpPoster
+	if opts.postTo != "" {
+		contentType := "text/plain; charset=utf-8"
+		switch opts.format {
+		case formatNDJSON:
+			contentType = "application/x-ndjson"
+		case formatJSON:
+			contentType = "application/json"
+		case formatMarkdown:
+			contentType = "text/markdown; charset=utf-8"
+		case formatXML:
+			contentType = "application/xml"
+		}
+		poster, err = newHTTPPoster(opts.postTo, opts.postHeaders, contentType)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating request: %v\n", err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, poster)
+	}
+
+	var chunks *chunker
+	if budget, _ := chunkBudget(opts); budget > 0 {
+		chunks = &chunker{}
+		sinks = append(sinks, chunks)
+	}
+	out := io.MultiWriter(sinks...)
+
+	// Offsets are counted as the output is written so the section index can
+	// record where each file's section lies
+	var sections *sectionIndex
+	var counter *countingWriter
+	if opts.sectionIndex != "" {
+		counter = &countingWriter{w: out}
+		out = counter
+		sections = &sectionIndex{}
+	}
 
 	// Initialize ignore lists
-	ignoreList, err := NewIgnoreList(*dirPath)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	ignoreList := loadIgnoreLists(opts)
+	if opts.includeExpander != nil {
+		opts.includeExpander.ignoreList = ignoreList
 	}
 
-	// Write header with metadata
-	header := fmt.Sprintf("# Combined File Contents\n# Generated: %s\n# Source Directory: %s\n\n",
-		time.Now().Format("2006-01-02 15:04:05"), *dirPath)
-	if _, err := outputFile.WriteString(header); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing header: %v\n", err)
-		os.Exit(1)
+	var gitChecker *gitIgnoreChecker
+	if opts.gitCheckIgnore {
+		gitChecker, err = newGitIgnoreChecker(opts.dirPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; falling back to built-in .gitignore matching\n", err)
+		} else {
+			ignoreList.disableGitIgnore()
+		}
+	}
+
+	if opts.ignoreAudit {
+		if err := ignoreList.enableAudit(opts.templateNames); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	if opts.nonceSeparators {
+		opts.separatorNonce, err = newNonce(16)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating separator nonce: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Separator nonce: %s\n", opts.separatorNonce)
+	}
+
+	// A generated run ID would make otherwise identical runs differ, so it is
+	// only added under -deterministic when given explicitly
+	if opts.runID == "" && !opts.deterministic {
+		opts.runID, err = newRunID()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating run ID: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	run := runInfo{source: opts.dirPath, runID: opts.runID, nonce: opts.separatorNonce}
+	if !opts.deterministic {
+		run.generated = time.Now()
+	}
+	header := textHeader(run)
+
+	var routes *router
+	if len(opts.routes) > 0 {
+		routes, err = newRouter(opts.routes, header)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating routed output files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The walk leaves out every file this run writes: the outputs themselves,
+	// the section index, and the chunk, index and shard files named after the
+	// output, including ones left by earlier runs
+	var ownOutputs []string
+	var absOutputPath string
+	if opts.outputPath != "" {
+		absOutputPath, _ = filepath.Abs(opts.outputPath)
+		ownOutputs = append(ownOutputs, absOutputPath)
+	}
+	for _, path := range opts.routes {
+		absRoutePath, _ := filepath.Abs(path)
+		ownOutputs = append(ownOutputs, absRoutePath)
+	}
+	if opts.sectionIndex != "" {
+		absIndexPath, _ := filepath.Abs(opts.sectionIndex)
+		ownOutputs = append(ownOutputs, absIndexPath)
+	}
+
+	var cache *fileCache
+	if opts.cacheDir != "" {
+		cache, err = newFileCache(opts.cacheDir, opts.clearCache)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening cache: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Create channels for the worker pool
@@ -191,9 +790,9 @@ func main() {
 
 	// Start worker pool
 	var wg sync.WaitGroup
-	for i := 0; i < *workers; i++ {
+	for i := 0; i < opts.workers; i++ {
 		wg.Add(1)
-		go worker(jobs, results, ignoreList, *dirPath, &wg)
+		go worker(jobs, results, ignoreList, cache, opts, &wg)
 	}
 
 	// Start a goroutine to close results channel once all workers are done
@@ -202,23 +801,88 @@ func main() {
 		close(results)
 	}()
 
+	// Directories skipped by -skip-unreadable; only the walk goroutine
+	// appends, and it finishes before the results loop below ends
+	var unreadableDirs []string
+
+	var progress *progressMeter
+	if opts.progress {
+		progress = newProgressMeter()
+	}
+
 	// Start a goroutine to walk the directory and send jobs
 	go func() {
-		err := filepath.Walk(*dirPath, func(path string, info os.FileInfo, err error) error {
+		// Paths are queued in batches when git decides what is ignored so each
+		// git check-ignore call answers for many paths at once
+		var batch []string
+		flush := func() error {
+			if gitChecker != nil {
+				kept, err := gitChecker.filter(batch, opts)
+				if err != nil {
+					return err
+				}
+				// Paths git ignores no longer count towards the total
+				progress.add(len(kept) - len(batch))
+				batch = kept
+			}
+			for _, path := range batch {
+				jobs <- path
+				progress.advance()
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		limiter := newDirLimiter(opts.maxOpenDirs)
+		err := walkTree(opts.dirPath, limiter, opts.readDirBatch, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
+				if opts.skipUnreadable && info != nil && info.IsDir() && errors.Is(err, fs.ErrPermission) {
+					fmt.Fprintf(os.Stderr, "Warning: skipping unreadable directory %s: %v\n", path, err)
+					unreadableDirs = append(unreadableDirs, path)
+					return filepath.SkipDir
+				}
 				return err
 			}
 
-			// Skip the output file itself
-			absOutputPath, _ := filepath.Abs(*outputPath)
-			absPath, _ := filepath.Abs(path)
-			if absPath == absOutputPath {
-				return nil
+			// Prune ignored and excluded directories instead of walking
+			// them, and pick up the .gitignore of any other
+			if info.IsDir() && path != opts.dirPath {
+				prune, err := enterDir(path, ignoreList, gitChecker, opts)
+				if prune {
+					return filepath.SkipDir
+				}
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+
+			// Skip the output files themselves
+			if len(ownOutputs) > 0 && !info.IsDir() {
+				absPath, _ := filepath.Abs(path)
+				if slices.Contains(ownOutputs, absPath) || absOutputPath != "" && isOutputSibling(absOutputPath, absPath) {
+					return nil
+				}
 			}
 
-			jobs <- path
+			progress.add(1)
+			if gitChecker == nil {
+				jobs <- path
+				progress.advance()
+				return nil
+			}
+			batch = append(batch, path)
+			if len(batch) >= gitCheckBatchSize {
+				return flush()
+			}
 			return nil
 		})
+		if err == nil {
+			progress.walkDone()
+			err = flush()
+		}
+		if gitChecker != nil {
+			gitChecker.close()
+		}
 
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error walking directory: %v\n", err)
@@ -229,16 +893,423 @@ func main() {
 	}()
 
 	// Process results and write to output file
-	for entry := range results {
+	summary := &Summary{countTokens: opts.tokenizer.count}
+	if opts.summaryByDir {
+		summary.byDir = make(map[string]*dirStats)
+	}
+	if opts.sizeHistogram {
+		summary.sizeHistogram = make([]dirStats, len(sizeBucketLabels))
+	}
+	var leaves []treeLeaf
+	record := func(entry *FileEntry, err error) {
+		if err == nil {
+			summary.add(entry)
+			if entry.omitReason != "" {
+				summary.addSkipped(entry.omitReason, entry.path)
+			}
+		}
+		if opts.reportSecrets || opts.failOnSecrets {
+			for _, secret := range entry.secrets {
+				fmt.Fprintf(os.Stderr, "Warning: possible secret (%s) in %s:%d\n", secret.kind, entry.path, secret.line)
+			}
+		}
+		for _, warning := range entry.warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+		summary.secrets += len(entry.secrets)
+		if entry.syntaxError != "" {
+			summary.syntaxErrors++
+		}
+		if guess := entry.encoding; guess != nil {
+			if guess.confidence < lowEncodingConfidence {
+				fmt.Fprintf(os.Stderr, "Warning: low-confidence encoding detection for %s: %s (confidence %.2f)\n", entry.path, guess.name, guess.confidence)
+			} else if opts.verbose {
+				fmt.Fprintf(os.Stderr, "Transcoded %s from %s (confidence %.2f)\n", entry.path, guess.name, guess.confidence)
+			}
+		}
+		if opts.validateUTF8 && !isBinary(entry.content) {
+			if offset := firstInvalidUTF8(entry.content); offset >= 0 {
+				fmt.Fprintf(os.Stderr, "Warning: invalid UTF-8 in %s at byte offset %d\n", entry.path, offset)
+				summary.invalidUTF8++
+			}
+		}
+		if entry.release != nil {
+			entry.release()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", entry.path, err)
+			summary.addError(entry.path, err)
+			return
+		}
+
+		if opts.treeHash || opts.manifest {
+			leaves = append(leaves, treeLeaf{path: entry.relPath, hash: entry.hash, changeHash: entry.changeHash})
+		}
+	}
+	var shards *shardWriter
+	if opts.shards > 1 {
+		shards, err = newShardWriter(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating shard files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	writer := newFormatWriter(out, run, opts)
+	if err := writer.begin(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing header: %v\n", err)
+		os.Exit(1)
+	}
+	emit := func(entry *FileEntry) {
+		if shards != nil {
+			shards.send(entry)
+			return
+		}
+		if chunks != nil {
+			chunks.begin(displayPath(entry, opts))
+		}
+		if opts.format != formatText {
+			record(entry, writer.writeEntry(entry))
+			return
+		}
+		if routes != nil {
+			if target := routes.target(entry); target != nil {
+				record(entry, writeFileEntry(target, entry, opts))
+				return
+			}
+		}
+		if sections != nil {
+			content, hash, err := renderSection(entry, opts)
+			if err == nil {
+				start := counter.n
+				_, err = out.Write(content)
+				sections.Sections = append(sections.Sections, sectionRange{Path: entry.path, Start: start, End: counter.n, Hash: hash})
+			}
+			record(entry, err)
+			return
+		}
+		record(entry, writeFileEntry(out, entry, opts))
+	}
+
+	reject := func(entry *FileEntry) {
 		if entry.err != nil {
 			fmt.Fprintf(os.Stderr, "Error processing %s: %v\n", entry.path, entry.err)
+			summary.addError(entry.path, entry.err)
+			return
+		}
+		if opts.verbose && entry.skipDetail != "" {
+			fmt.Fprintf(os.Stderr, "Skipping %s file: %s (%s)\n", entry.skipReason, entry.path, entry.skipDetail)
+		} else {
+			fmt.Fprintf(os.Stderr, "Skipping %s file: %s\n", entry.skipReason, entry.path)
+		}
+		summary.addSkipped(entry.skipReason, entry.path)
+	}
+
+	var buffered, rejected []*FileEntry
+	for entry := range results {
+		if entry.err != nil || entry.skipReason != "" {
+			// Sorted runs report problems in path order too, so nothing they
+			// print depends on which worker finished first
+			if opts.sortOutput {
+				rejected = append(rejected, entry)
+				continue
+			}
+			reject(entry)
 			continue
 		}
 
-		if err := writeFileEntry(outputFile, entry); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing %s: %v\n", entry.path, err)
+		// Sorted output has to wait until every worker has finished
+		if opts.sortOutput {
+			buffered = append(buffered, entry)
+			continue
 		}
+		emit(entry)
+	}
+
+	progress.finish()
+
+	slices.SortFunc(rejected, func(a, b *FileEntry) int {
+		return strings.Compare(a.path, b.path)
+	})
+	for _, entry := range rejected {
+		reject(entry)
 	}
 
-	fmt.Printf("Successfully combined files into: %s\n", *outputPath)
+	summary.unreadableDirs = unreadableDirs
+
+	for _, path := range opts.lineRanges.unmatched() {
+		fmt.Fprintf(os.Stderr, "Warning: -range path %s does not match any included file\n", path)
+	}
+
+	sortEntries(buffered, opts)
+	if opts.lowercasePaths {
+		var duplicates []*FileEntry
+		buffered, duplicates = dedupFoldedPaths(buffered)
+		for _, entry := range duplicates {
+			fmt.Fprintf(os.Stderr, "Skipping %s file: %s\n", skipDuplicatePath, entry.path)
+			summary.addSkipped(skipDuplicatePath, entry.path)
+			if entry.release != nil {
+				entry.release()
+			}
+		}
+	}
+	if len(opts.reachableFrom) > 0 {
+		var unreachable []*FileEntry
+		var missing []string
+		buffered, unreachable, missing = filterReachable(buffered, opts.reachableFrom, opts.dirPath)
+		for _, root := range missing {
+			fmt.Fprintf(os.Stderr, "Warning: -reachable-from root %s is not among the included files\n", root)
+		}
+		for _, entry := range unreachable {
+			summary.addSkipped(skipUnreachable, entry.path)
+			if entry.release != nil {
+				entry.release()
+			}
+		}
+	}
+	if opts.sortMode == sortTopo {
+		buffered = sortTopological(buffered, opts.dirPath)
+	}
+	now := time.Now()
+	if opts.modifiedWithin != "" {
+		var stale []*FileEntry
+		buffered, stale = filterRecent(buffered, opts.modifiedWithin, now)
+		for _, entry := range stale {
+			summary.addSkipped(skipNotRecent, entry.path)
+			if entry.release != nil {
+				entry.release()
+			}
+		}
+	}
+	if opts.perLanguageCap > 0 {
+		buffered, summary.languageCapped = applyLanguageCap(buffered, opts.perLanguageCap)
+	}
+	if opts.maxDirBytes > 0 {
+		buffered, summary.dirCapped = applyDirByteCap(buffered, opts.maxDirBytes)
+	}
+	var tokens *tokenReport
+	if opts.tokenReport {
+		tokens = newTokenReport(buffered, opts)
+	}
+	if opts.maxTokens > 0 {
+		var overBudget, truncated []*FileEntry
+		var report budgetReport
+		if opts.overBudget == overBudgetTruncate {
+			buffered, overBudget, truncated, report = truncateToBudget(buffered, opts.maxTokens, opts)
+		} else {
+			buffered, overBudget, report = fitTokenBudget(buffered, opts.maxTokens, opts.fitBudget, opts)
+		}
+		summary.tokenBudget = &report
+		for _, entry := range overBudget {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "Skipping %s file: %s (%d tokens)\n", skipOverBudget, entry.path, entryTokens(entry, opts))
+			}
+			summary.addSkipped(skipOverBudget, entry.path)
+			if entry.release != nil {
+				entry.release()
+			}
+		}
+		if tokens != nil {
+			tokens.mark(overBudget, "dropped")
+			tokens.mark(truncated, "truncated")
+		}
+	}
+	if tokens != nil {
+		tokens.print(os.Stderr, opts)
+	}
+	mergeLimit := opts.mergeSmallUnder
+	if opts.format != formatText {
+		mergeLimit = 0
+	}
+	var items []outputItem
+	if opts.recencyGroups {
+		items = planRecencySections(buffered, mergeLimit, now)
+	} else {
+		items = planMerges(buffered, mergeLimit)
+	}
+	if contents, ok := writer.(contentsWriter); ok {
+		entries := make([]*FileEntry, 0, len(items))
+		for _, item := range items {
+			if item.entry != nil {
+				entries = append(entries, item.entry)
+			}
+		}
+		if err := contents.writeContents(entries); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing contents: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if opts.citeIndex {
+		numbered := assignCiteIndices(items)
+		if opts.format == formatText {
+			if err := writeCiteIndex(out, numbered, opts); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing file index: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	for _, item := range items {
+		if item.section != "" {
+			if opts.format != formatText {
+				continue
+			}
+			if chunks != nil {
+				chunks.begin()
+			}
+			if err := writeSectionHeader(out, item.section); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing section header: %v\n", err)
+				os.Exit(1)
+			}
+			continue
+		}
+
+		if item.group == nil {
+			emit(item.entry)
+			continue
+		}
+
+		if chunks != nil {
+			paths := make([]string, len(item.group.entries))
+			for i, entry := range item.group.entries {
+				paths[i] = displayPath(entry, opts)
+			}
+			chunks.begin(paths...)
+		}
+		err := writeMergedGroup(out, item.group, opts)
+		for _, entry := range item.group.entries {
+			record(entry, err)
+		}
+	}
+
+	if shards != nil {
+		results := shards.close()
+		for _, result := range results {
+			record(result.entry, result.err)
+		}
+
+		if err := shards.writeAssignments(results); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing shard assignments: %v\n", err)
+		}
+
+		if opts.concatShards {
+			err = shards.concat(out)
+		} else {
+			_, err = fmt.Fprintf(out, "# Shards: %s\n", strings.Join(shards.paths, ", "))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing shards: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if opts.manifest && opts.format == formatText {
+		if chunks != nil {
+			chunks.begin()
+		}
+		if err := writeManifest(out, leaves); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing manifest: %v\n", err)
+		}
+	}
+
+	// Write the tree hash footer so consumers can detect changes cheaply
+	if opts.treeHash {
+		summary.treeHash = treeHash(leaves)
+		if opts.format == formatText {
+			if chunks != nil {
+				chunks.begin()
+			}
+			if _, err := fmt.Fprintf(out, "\n%s%s\n", treeHashFooter, summary.treeHash); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing tree hash: %v\n", err)
+			}
+		}
+	}
+
+	if opts.format != formatText {
+		stats := summary.jsonStats()
+		if !opts.deterministic {
+			stats.Duration = time.Since(started).Seconds()
+		}
+		if err := writer.end(stats); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing stats: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error finishing compressed output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if sections != nil {
+		sections.Nonce = opts.separatorNonce
+		if err := writeSectionIndex(opts.sectionIndex, opts.outputPath, sections); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing section index: %v\n", err)
+		}
+	}
+
+	if opts.outputPath != "" {
+		fmt.Printf("Successfully combined files into: %s\n", opts.outputPath)
+	}
+	if routes != nil {
+		if err := routes.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error finishing routed output files: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Routed files by category into: %s\n", strings.Join(routes.paths, ", "))
+	}
+
+	if poster != nil {
+		status, err := poster.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error posting to %s: %v\n", opts.postTo, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Posted combined output to %s: %s\n", opts.postTo, status)
+	}
+
+	if chunks != nil {
+		budget, count := chunkBudget(opts)
+		paths, err := writeChunks(opts.outputPath, chunks.split(budget, opts.chunkOverlap, opts.splitBoundary, count))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing chunks: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Wrote %d chunk files and %s\n", len(paths), chunkIndexPath(opts.outputPath))
+	}
+
+	if opts.clipboard {
+		// Very large clipboard contents can hang or be silently truncated by
+		// the OS, so they are only copied when explicitly forced
+		if size := int64(clipboardBuf.Len()); opts.clipboardMaxBytes > 0 && size > opts.clipboardMaxBytes && !opts.force {
+			fmt.Fprintf(os.Stderr, "Error: combined output is %d bytes, above the %d byte clipboard limit; use -force to copy anyway\n",
+				size, opts.clipboardMaxBytes)
+			os.Exit(1)
+		}
+		if err := copyToClipboard(clipboardBuf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error copying to clipboard: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Copied combined output to clipboard")
+	}
+	summary.print(os.Stdout)
+	if opts.validate {
+		summary.printProblems(os.Stdout)
+	}
+
+	if opts.onChangeExec != "" && summary.treeHash != lastTreeHash {
+		if err := runOnChange(opts.onChangeExec, opts.outputPath, summary.treeHash); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running on-change command: %v\n", err)
+		}
+	}
+
+	if ignoreList.audit != nil {
+		ignoreList.audit.report(os.Stdout)
+	}
+
+	if err := summary.checkExpectations(opts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 }
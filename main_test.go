@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain lets tests run the program itself: a child started by
+// runSinglegen executes main with its own arguments instead of the tests
+func TestMain(m *testing.M) {
+	if os.Getenv("SINGLEGEN_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runSinglegen runs the program with args in a child process and fails the
+// test if it exits non-zero
+func runSinglegen(t *testing.T, args ...string) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "SINGLEGEN_TEST_MAIN=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("singlegen %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// writeTestTree creates a tree with enough files in enough directories for
+// workers to finish out of order, including duplicate and empty files and
+// an ignored directory
+func writeTestTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":        "build/\n",
+		"build/out.txt":     "ignored\n",
+		"empty.txt":         "",
+		"dup/a.txt":         "same content\n",
+		"dup/b.txt":         "same content\n",
+		"config/app.json":   "{\"name\": \"app\"}\n",
+		"docs/README.md":    "# Docs\n\nSome text.\n",
+		"src/main.go":       "package main\n\nfunc main() {}\n",
+		"src/util/util.go":  "package util\n",
+		"src/util/extra.go": "package util\n\nvar X = 1\n",
+	}
+	for i := range 40 {
+		files[fmt.Sprintf("gen/d%d/file%02d.txt", i%7, i)] = strings.Repeat(fmt.Sprintf("line %d\n", i), i*13)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestOutputIndependentOfWorkerCount checks that with sorting on, the
+// combined output is byte-identical whether one worker or many read the
+// files
+func TestOutputIndependentOfWorkerCount(t *testing.T) {
+	dir := writeTestTree(t)
+
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"text", nil},
+		{"manifest and tree hash", []string{"-manifest", "-tree-hash"}},
+		{"merged small files", []string{"-merge-small-under", "64"}},
+		{"markdown", []string{"-format", "markdown"}},
+		{"json", []string{"-format", "json", "-json-with-stats"}},
+		{"ndjson", []string{"-format", "ndjson"}},
+		{"xml", []string{"-format", "xml"}},
+		{"token budget", []string{"-max-tokens", "2000", "-fit-budget"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outputs [][]byte
+			for _, workers := range []string{"1", "8"} {
+				output := filepath.Join(t.TempDir(), "out.txt")
+				args := append([]string{"-dir", dir, "-output", output, "-deterministic", "-relative-paths", "-workers", workers}, tt.args...)
+				runSinglegen(t, args...)
+
+				data, err := os.ReadFile(output)
+				if err != nil {
+					t.Fatal(err)
+				}
+				outputs = append(outputs, data)
+			}
+			if !bytes.Contains(outputs[0], []byte("src/util/extra.go")) {
+				t.Fatalf("output is missing files:\n%s", outputs[0])
+			}
+			if !bytes.Equal(outputs[0], outputs[1]) {
+				t.Errorf("output with 1 worker differs from output with 8 workers\n1 worker:\n%s\n8 workers:\n%s", outputs[0], outputs[1])
+			}
+		})
+	}
+}
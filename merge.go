@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+)
+
+// mergeGroup is a set of small files from one directory that are written
+// under a single header to save per-file header overhead
+type mergeGroup struct {
+	dir     string
+	entries []*FileEntry
+}
+
+// outputItem is either a single entry, a merged group of small entries, or
+// the header of a section of the output
+type outputItem struct {
+	entry   *FileEntry
+	group   *mergeGroup
+	section string
+}
+
+// planMerges turns sorted entries into output items. Files smaller than limit
+// are folded into one group per directory, placed where the first of them
+// appeared; a directory with a single small file keeps it as a normal entry.
+func planMerges(entries []*FileEntry, limit int64) []outputItem {
+	isSmall := func(entry *FileEntry) bool {
+		return limit > 0 && int64(len(entry.content)) < limit
+	}
+
+	smallPerDir := make(map[string]int)
+	for _, entry := range entries {
+		if isSmall(entry) {
+			smallPerDir[filepath.Dir(entry.relPath)]++
+		}
+	}
+
+	groups := make(map[string]*mergeGroup)
+	var items []outputItem
+	for _, entry := range entries {
+		dir := filepath.Dir(entry.relPath)
+		if !isSmall(entry) || smallPerDir[dir] < 2 {
+			items = append(items, outputItem{entry: entry})
+			continue
+		}
+
+		group, ok := groups[dir]
+		if !ok {
+			group = &mergeGroup{dir: dir}
+			groups[dir] = group
+			items = append(items, outputItem{group: group})
+		}
+		group.entries = append(group.entries, entry)
+	}
+
+	return items
+}
+
+func writeMergedGroup(out io.Writer, group *mergeGroup, opts *Options) error {
+	header := "\n"
+	if opts.separatorNonce != "" {
+		header += separatorLine(opts.separatorNonce, "START")
+	}
+	header += fmt.Sprintf("### Directory: %s\n### Merged Small Files: %d\n\n",
+		filepath.ToSlash(group.dir), len(group.entries))
+
+	if _, err := io.WriteString(out, header); err != nil {
+		return err
+	}
+
+	for _, entry := range group.entries {
+		if _, err := fmt.Fprintf(out, "#### %s: %s (%d bytes)\n", fileLabel(entry), displayPath(entry, opts), entry.info.Size()); err != nil {
+			return err
+		}
+
+		content := entry.content
+		if entry.omitReason != "" {
+			content = []byte(omitNote(entry))
+		}
+		if _, err := out.Write(content); err != nil {
+			return err
+		}
+
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			if _, err := io.WriteString(out, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := io.WriteString(out, "\n"); err != nil {
+		return err
+	}
+
+	if opts.separatorNonce != "" {
+		if _, err := io.WriteString(out, separatorLine(opts.separatorNonce, "END")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
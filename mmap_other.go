@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is unavailable on this platform, so callers fall back to reading
+func mmapFile(file *os.File, size int64) ([]byte, func(), error) {
+	return nil, nil, errors.New("mmap is not supported on this platform")
+}
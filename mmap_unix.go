@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// mmapFile maps the first size bytes of file read-only. The mapping stays
+// valid after the file is closed; the returned release function unmaps it,
+// after which the content must no longer be used.
+func mmapFile(file *os.File, size int64) ([]byte, func(), error) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, fmt.Errorf("cannot map %d bytes", size)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() { syscall.Munmap(data) })
+	}
+	return data, release, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ndjsonRecord is one line of -format ndjson output and one element of the
+// -format json array. In ndjson, content is included only for the first file
+// carrying a given hash, so consumers can store each distinct content once
+// and reference it by hash for duplicates; json records always carry it.
+// Content follows -preview-lines and -sample-sections like the other
+// formats. Files left out of the output are marked by "omitted" and carry
+// neither content, hash nor first-occurrence flag. Source is what the path starts with: the
+// scanned directory, or the -dir alias; it is empty for -relative-paths.
+type ndjsonRecord struct {
+	Type      string  `json:"type,omitempty"`
+	Index     int     `json:"index,omitempty"`
+	Path      string  `json:"path"`
+	Source    string  `json:"source,omitempty"`
+	Submodule string  `json:"submodule,omitempty"`
+	Size      int64   `json:"size"`
+	ModTime   string  `json:"mtime,omitempty"`
+	Hash      string  `json:"hash,omitempty"`
+	First     *bool   `json:"first,omitempty"`
+	Omitted   string  `json:"omitted,omitempty"`
+	Syntax    string  `json:"syntax_error,omitempty"`
+	Content   *string `json:"content,omitempty"`
+
+	// OmittedLines counts the lines -preview-lines left out of Content
+	OmittedLines int `json:"omitted_lines,omitempty"`
+}
+
+// jsonStats is the run summary added by -json-with-stats. Duration is left
+// out under -deterministic since it differs between runs.
+type jsonStats struct {
+	Type     string  `json:"type,omitempty"`
+	Files    int     `json:"files"`
+	Bytes    int64   `json:"bytes"`
+	Tokens   int     `json:"tokens"`
+	Errors   int     `json:"errors"`
+	Skipped  int     `json:"skipped"`
+	Duration float64 `json:"duration_seconds,omitempty"`
+	TreeHash string  `json:"tree_hash,omitempty"`
+}
+
+// ndjsonWriter writes entries as JSON records. The layout depends on the
+// format and on -json-with-stats:
+//
+//	ndjson                   one file record per line
+//	ndjson -json-with-stats  file records with "type": "file", then one
+//	                         record with "type": "stats"
+//	json                     [file, ...]
+//	json -json-with-stats    {"files": [file, ...], "stats": {...}}
+//
+// It is only used from the single goroutine that writes output, so the seen
+// set needs no locking.
+type ndjsonWriter struct {
+	out     io.Writer
+	opts    *Options
+	seen    map[string]bool
+	written int
+}
+
+func newNDJSONWriter(out io.Writer, opts *Options) *ndjsonWriter {
+	return &ndjsonWriter{out: out, opts: opts, seen: make(map[string]bool)}
+}
+
+func (w *ndjsonWriter) writeEntry(entry *FileEntry) error {
+	record := ndjsonRecord{
+		Index:     entry.citeIndex,
+		Path:      displayPath(entry, w.opts),
+		Source:    pathSource(w.opts),
+		Submodule: entry.submodule,
+		Size:      entry.info.Size(),
+		Syntax:    entry.syntaxError,
+	}
+	if w.opts.format == formatNDJSON && w.opts.jsonWithStats {
+		record.Type = "file"
+	}
+	if !w.opts.deterministic {
+		record.ModTime = entry.info.ModTime().Format("2006-01-02T15:04:05Z07:00")
+	}
+	if entry.omitReason != "" {
+		record.Omitted = entry.omitReason
+	} else {
+		first := !w.seen[entry.hash]
+		record.Hash, record.First = entry.hash, &first
+		w.seen[entry.hash] = true
+		if first || w.opts.format == formatJSON {
+			body, omitted := entryBody(entry, w.opts)
+			content := string(body)
+			record.Content, record.OmittedLines = &content, omitted
+		}
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if w.opts.format == formatJSON && w.written > 0 {
+		data = append([]byte(","), data...)
+	}
+	data = append(data, '\n')
+	w.written++
+
+	_, err = w.out.Write(data)
+	return err
+}
+
+// begin opens the document for -format json; ndjson needs no preamble
+func (w *ndjsonWriter) begin() error {
+	if w.opts.format != formatJSON {
+		return nil
+	}
+	opening := "[\n"
+	if w.opts.jsonWithStats {
+		opening = "{\"files\":[\n"
+	}
+	_, err := io.WriteString(w.out, opening)
+	return err
+}
+
+// end closes the document for -format json and adds the stats when
+// -json-with-stats is set
+func (w *ndjsonWriter) end(stats jsonStats) error {
+	if !w.opts.jsonWithStats {
+		if w.opts.format == formatJSON {
+			_, err := io.WriteString(w.out, "]\n")
+			return err
+		}
+		return nil
+	}
+
+	if w.opts.format == formatNDJSON {
+		stats.Type = "stats"
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	if w.opts.format == formatJSON {
+		data = append(append([]byte("],\"stats\":"), data...), '}')
+	}
+	data = append(data, '\n')
+
+	_, err = w.out.Write(data)
+	return err
+}
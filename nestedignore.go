@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// rootRelativePattern rewrites a line from the .gitignore in dir, a
+// slash-separated path relative to the scanned directory, so that it matches
+// the same paths when evaluated from the root. Blank lines and comments come
+// back empty.
+func rootRelativePattern(dir, line string) string {
+	line = strings.TrimRight(line, "\r ")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	negate := ""
+	if strings.HasPrefix(line, "!") {
+		negate, line = "!", line[1:]
+	}
+
+	// As in git, a pattern with a slash other than a trailing one is
+	// relative to its .gitignore; any other pattern matches at every depth
+	// below it
+	switch {
+	case strings.HasPrefix(line, "/"):
+		return negate + "/" + dir + line
+	case strings.Contains(strings.TrimSuffix(line, "/"), "/"):
+		return negate + "/" + dir + "/" + line
+	}
+	return negate + "/" + dir + "/**/" + line
+}
+
+// gitignoreLines reads the patterns of a .gitignore, rewritten relative to
+// the scanned directory when the file is in the subdirectory dir
+func gitignoreLines(file, dir string) ([]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseGitignoreLines(data, dir), nil
+}
+
+// parseGitignoreLines is gitignoreLines for a file that has already been read
+func parseGitignoreLines(data []byte, dir string) []string {
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if dir != "" {
+			line = rootRelativePattern(dir, line)
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// loadNestedGitignore picks up the .gitignore in the subdirectory relDir, if
+// there is one. Its patterns are appended to those of every .gitignore above
+// it and compiled into one matcher for the paths below relDir, so deeper
+// files take precedence and can re-include what a parent ignored, as in git.
+// The walker calls this for each directory before visiting its contents.
+func (il *IgnoreList) loadNestedGitignore(relDir string) error {
+	il.mu.RLock()
+	disabled := il.gitDisabled
+	il.mu.RUnlock()
+	if disabled {
+		return nil
+	}
+
+	file := filepath.Join(il.root, relDir, ".gitignore")
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("error loading %s: %v", file, err)
+	}
+	dir := filepath.ToSlash(relDir)
+	own := parseGitignoreLines(data, dir)
+
+	il.mu.Lock()
+	defer il.mu.Unlock()
+
+	// Holding the write lock keeps shouldIgnore from recording against the
+	// audit's rules while they grow
+	il.sources = append(il.sources, file)
+	if il.audit != nil {
+		il.audit.addSource(file, dir, data)
+	}
+
+	lines := append(append([]string(nil), il.nestedLinesFor(dir)...), own...)
+	if il.nested == nil {
+		il.nested = make(map[string]*gitignore.GitIgnore)
+		il.nestedLines = make(map[string][]string)
+	}
+	il.nested[dir] = gitignore.CompileIgnoreLines(lines...)
+	il.nestedLines[dir] = lines
+	return nil
+}
+
+// nestedLinesFor returns the accumulated patterns that apply inside dir,
+// from its nearest ancestor with a .gitignore. il.mu must be held.
+func (il *IgnoreList) nestedLinesFor(dir string) []string {
+	for d := path.Dir(dir); d != "."; d = path.Dir(d) {
+		if lines, ok := il.nestedLines[d]; ok {
+			return lines
+		}
+	}
+	return il.rootLines
+}
+
+// gitignoreFor returns the matcher for relPath: that of the nearest
+// directory above it with its own .gitignore, or the root one. il.mu must be
+// held.
+func (il *IgnoreList) gitignoreFor(relPath string) *gitignore.GitIgnore {
+	if len(il.nested) > 0 {
+		for d := path.Dir(filepath.ToSlash(strings.TrimSuffix(relPath, string(filepath.Separator)))); d != "."; d = path.Dir(d) {
+			if matcher, ok := il.nested[d]; ok {
+				return matcher
+			}
+		}
+	}
+	return il.gitIgnore
+}
+
+// enterDir is called as the walk reaches the directory at path. It reports
+// whether the directory is ignored or excluded, so that nothing below it is
+// walked, and otherwise loads its .gitignore into the list that governs it.
+// With a gitChecker, git decides whether directories outside submodules are
+// ignored.
+func enterDir(path string, ignoreList *IgnoreList, gitChecker *gitIgnoreChecker, opts *Options) (bool, error) {
+	relPath, err := filepath.Rel(opts.dirPath, path)
+	if err != nil {
+		return false, err
+	}
+	if opts.excludes.match(relPath+string(filepath.Separator)) >= 0 {
+		return true, nil
+	}
+
+	list, listPath := ignoreList, relPath
+	sub, subPath := findSubmodule(opts.submodules, relPath)
+	if sub != nil {
+		list, listPath = sub.ignoreList, subPath
+	}
+	if gitChecker != nil && sub == nil {
+		ignored, err := gitChecker.ignoredDir(relPath)
+		if err != nil {
+			return false, err
+		}
+		if ignored {
+			return true, nil
+		}
+	}
+	// The trailing separator lets directory-only patterns such as 'build/'
+	// match
+	if list.shouldIgnore(listPath + string(filepath.Separator)) {
+		return true, nil
+	}
+
+	// A submodule's own .gitignore is the root of its list
+	for _, sub := range opts.submodules {
+		if filepath.ToSlash(relPath) == sub.path {
+			return false, nil
+		}
+	}
+	return false, list.loadNestedGitignore(listPath)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// newNonce returns a random hex string of n bytes, used where the output
+// needs a per-run value that is practically guaranteed not to occur in content
+func newNonce(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newRunID returns a random version 4 UUID identifying a single run
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// separatorLine returns the delimiter marking the start or end of a file
+// section for the given run nonce
+func separatorLine(nonce, kind string) string {
+	return "===SINGLEGEN-" + nonce + "-FILE-" + kind + "===\n"
+}
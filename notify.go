@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const treeHashFooter = "# Tree Hash: "
+
+// previousTreeHash returns the tree hash recorded in the footer of an existing
+// output file, or an empty string if there is none
+func previousTreeHash(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ""
+	}
+
+	// The footer is the last line, so only the tail needs to be read
+	const tailSize = 4096
+	offset := max(info.Size()-tailSize, 0)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(tail, offset); err != nil && err != io.EOF {
+		return ""
+	}
+
+	idx := bytes.LastIndex(tail, []byte(treeHashFooter))
+	if idx < 0 {
+		return ""
+	}
+	line, _, _ := strings.Cut(string(tail[idx+len(treeHashFooter):]), "\n")
+	return strings.TrimSpace(line)
+}
+
+// runOnChange runs the -on-change-exec command through the shell. The output
+// path and new tree hash are passed as positional arguments ($1 and $2) and as
+// SINGLEGEN_OUTPUT and SINGLEGEN_TREE_HASH in the environment.
+func runOnChange(command, outputPath, hash string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command, outputPath, hash)
+	} else {
+		cmd = exec.Command("sh", "-c", command, "sh", outputPath, hash)
+	}
+
+	cmd.Env = append(os.Environ(), "SINGLEGEN_OUTPUT="+outputPath, "SINGLEGEN_TREE_HASH="+hash)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
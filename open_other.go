@@ -0,0 +1,16 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// openFile opens path for reading. Only Windows needs special sharing flags.
+func openFile(path string) (*os.File, error) {
+	return os.Open(path)
+}
+
+// describeLockError returns err unchanged; advisory locks elsewhere never
+// prevent reading
+func describeLockError(err error) error {
+	return err
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// Windows error codes for files another process holds locked
+const (
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// openFile opens path for reading without denying other processes read,
+// write or delete access, so files held open by editors and build tools can
+// still be read
+func openFile(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	handle, err := syscall.CreateFile(name, syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: describeLockError(err)}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
+
+// describeLockError explains sharing and lock violations, which otherwise
+// surface as opaque system errors
+func describeLockError(err error) error {
+	if errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation) {
+		return fmt.Errorf("file is locked by another process: %w", err)
+	}
+	return err
+}
@@ -0,0 +1,676 @@
+package main
+
+import (
+	"compress/flate"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// Output formats accepted by -format
+const (
+	formatText   = "text"
+	formatNDJSON = "ndjson"
+	formatJSON   = "json"
+
+	formatMarkdown = "markdown"
+	formatXML      = "xml"
+)
+
+// What -over-budget does with files beyond -max-tokens
+const (
+	overBudgetDrop     = "drop"
+	overBudgetTruncate = "truncate"
+)
+
+// Options holds the settings parsed from the command line
+type Options struct {
+	dirPath        string
+	dirAlias       string
+	outputPath     string
+	format         string
+	jsonWithStats  bool
+	workers        int
+	treeHash       bool
+	manifest       bool
+	onChangeExec   string
+	ignoreAudit    bool
+	readBufferPool bool
+	mmap           bool
+	mmapMinSize    int64
+	expectFiles    expectRange
+	expectBytes    expectRange
+	previewLines   int
+	lineRanges     lineRanges
+	maxSize        int64
+	sampleSections sampleSpec
+	includes       includeList
+	pathIncludeRe  regexpList
+	pathExcludeRe  regexpList
+	gitCheckIgnore bool
+
+	gitGlobalIgnore bool
+
+	gitignoreTemplates     string
+	listGitignoreTemplates bool
+	templateNames          []string
+	templateIgnore         *gitignore.GitIgnore
+
+	nonceSeparators bool
+	separatorNonce  string
+	clipboard       bool
+	maxOpenDirs     int
+	readDirBatch    int
+	skipUnreadable  bool
+
+	compress         string
+	compressionLevel int
+
+	postTo      string
+	postHeaders headerList
+
+	clipboardMaxBytes int64
+	force             bool
+
+	deterministic bool
+	runID         string
+	relativePaths bool
+	diffFriendly  bool
+	sortMode      string
+	sortOutput    bool
+
+	commentHeaders        bool
+	commentHeaderFallback string
+
+	headerTemplate  *template.Template
+	headerTemplates headerTemplateList
+
+	posixPaths     bool
+	lowercasePaths bool
+
+	mergeSmallUnder int64
+
+	validateUTF8 bool
+	strict       bool
+	summaryByDir bool
+
+	sizeHistogram bool
+
+	perLanguageCap int
+	maxDirBytes    int64
+
+	recurseSubmodules bool
+	submodules        []*submodule
+
+	readmeFirst    bool
+	indexNames     []string
+	citeIndex      bool
+	orderByInclude bool
+
+	reachableFrom []string
+
+	recencyGroups  bool
+	modifiedWithin string
+	skipRecent     time.Duration
+
+	fileTimeout time.Duration
+
+	skipMinified     bool
+	skipLockfileLike bool
+
+	shards       int
+	concatShards bool
+
+	cacheDir   string
+	clearCache bool
+
+	validate       bool
+	validateSyntax bool
+
+	hashIgnoreWhitespace bool
+
+	routes routeList
+
+	maxTokens int
+	fitBudget bool
+
+	tokenizer   *tokenizer
+	overBudget  string
+	tokenReport bool
+
+	unpackPath     string
+	unpackModTimes bool
+
+	excludes      includeList
+	includeBinary bool
+
+	watch        bool
+	sectionIndex string
+
+	chunkTokens   int
+	splitSize     int
+	chunkOverlap  int
+	splitBoundary string
+
+	stripImports bool
+
+	scrubAbsPaths bool
+
+	transcode bool
+	verbose   bool
+	progress  bool
+
+	expandIncludes   bool
+	includeDirective string
+	includeExpander  *includeExpander
+
+	redactSecrets bool
+	reportSecrets bool
+	failOnSecrets bool
+	secretConfig  secretConfig
+
+	replaceRules    []replaceRule
+	stripLinePrefix regexpList
+	transforms      []Transform
+}
+
+// regexpList is a repeatable flag of regular expressions compiled as they are
+// parsed so invalid patterns are reported at startup
+type regexpList []*regexp.Regexp
+
+func (l *regexpList) String() string {
+	if l == nil {
+		return ""
+	}
+
+	patterns := make([]string, len(*l))
+	for i, re := range *l {
+		patterns[i] = re.String()
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func (l *regexpList) Set(value string) error {
+	re, err := regexp.Compile(value)
+	if err != nil {
+		return fmt.Errorf("invalid regular expression %q: %v", value, err)
+	}
+	*l = append(*l, re)
+	return nil
+}
+
+// matchAny reports whether any expression in the list matches s
+func (l regexpList) matchAny(s string) bool {
+	for _, re := range l {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// expectRange is an inclusive MIN:MAX bound where either side may be omitted
+type expectRange struct {
+	min, max       int64
+	hasMin, hasMax bool
+}
+
+func (r *expectRange) String() string {
+	if r == nil || (!r.hasMin && !r.hasMax) {
+		return ""
+	}
+
+	var lo, hi string
+	if r.hasMin {
+		lo = strconv.FormatInt(r.min, 10)
+	}
+	if r.hasMax {
+		hi = strconv.FormatInt(r.max, 10)
+	}
+	return lo + ":" + hi
+}
+
+func (r *expectRange) Set(value string) error {
+	lo, hi, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("expected MIN:MAX, got %q", value)
+	}
+
+	if lo != "" {
+		n, err := strconv.ParseInt(lo, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid minimum %q", lo)
+		}
+		r.min, r.hasMin = n, true
+	}
+	if hi != "" {
+		n, err := strconv.ParseInt(hi, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid maximum %q", hi)
+		}
+		r.max, r.hasMax = n, true
+	}
+	if r.hasMin && r.hasMax && r.min > r.max {
+		return fmt.Errorf("minimum %d exceeds maximum %d", r.min, r.max)
+	}
+
+	return nil
+}
+
+// check reports an error when actual falls outside the expected range
+func (r *expectRange) check(name string, actual int64) error {
+	if r.hasMin && actual < r.min {
+		return fmt.Errorf("%s %d is below the expected minimum %d", name, actual, r.min)
+	}
+	if r.hasMax && actual > r.max {
+		return fmt.Errorf("%s %d is above the expected maximum %d", name, actual, r.max)
+	}
+	return nil
+}
+
+func parseOptions() (*Options, error) {
+	opts := &Options{routes: routeList{}}
+
+	flag.StringVar(&opts.dirPath, "dir", ".", "Directory to scan (default: current working directory); dir=alias shows alias in place of the directory in file paths")
+	flag.StringVar(&opts.outputPath, "output", "combined_output.txt", "Output file path (empty to skip writing a file)")
+	flag.StringVar(&opts.format, "format", formatText, "Output format: text, ndjson (one record per file with content hash), json (an array of the same records), markdown (a table of contents, then fenced code blocks) or xml")
+	flag.BoolVar(&opts.jsonWithStats, "json-with-stats", false, "Add run stats to json and ndjson output: json becomes {\"files\": [...], \"stats\": {...}}, ndjson ends with a stats record")
+	flag.IntVar(&opts.workers, "workers", runtime.NumCPU(), "Number of worker goroutines")
+	flag.BoolVar(&opts.hashIgnoreWhitespace, "hash-ignore-whitespace", false, "Hash content with whitespace runs collapsed for -tree-hash, so reformatting is not a change (-manifest keeps exact hashes; output is unchanged)")
+	flag.BoolVar(&opts.manifest, "manifest", false, "Write a footer listing each file's SHA-256 hash, sorted by relative path")
+	flag.BoolVar(&opts.treeHash, "tree-hash", false, "Compute a Merkle root hash over all included files")
+	flag.StringVar(&opts.onChangeExec, "on-change-exec", "", "Run this shell command when the tree hash differs from the previous output's; receives the output path and hash")
+	flag.BoolVar(&opts.gitCheckIgnore, "git-check-ignore", false, "Ask git which paths are ignored (batched git check-ignore), covering nested and global rules")
+	flag.BoolVar(&opts.gitGlobalIgnore, "git-global-ignore", false, "Also apply git's global excludes file (core.excludesfile)")
+	flag.StringVar(&opts.gitignoreTemplates, "gitignore-template", "", "Also ignore paths matched by these comma-separated bundled templates, e.g. node,python")
+	flag.BoolVar(&opts.listGitignoreTemplates, "list-gitignore-templates", false, "List the bundled gitignore templates and exit")
+	flag.BoolVar(&opts.ignoreAudit, "ignore-audit", false, "Report ignore patterns that never matched any path")
+	flag.BoolVar(&opts.validateSyntax, "validate-syntax", false, "Note parse errors in the headers of Go, JSON, XML and YAML files")
+	flag.BoolVar(&opts.validate, "validate", false, "Read and transform every file without writing output, list the files that fail, and exit non-zero if any do")
+	flag.StringVar(&opts.cacheDir, "cache-dir", "", "Cache each file's processed content here, keyed by path, and reuse it while size and mtime are unchanged")
+	flag.BoolVar(&opts.clearCache, "clear-cache", false, "Empty -cache-dir before the run")
+	flag.BoolVar(&opts.readBufferPool, "read-buffer-pool", false, "Reuse read buffers across files to reduce allocations")
+	flag.BoolVar(&opts.mmap, "mmap", false, "Memory-map large files instead of reading them, where the platform supports it; runs that hold entries for sorting copy content out of the mapping once read")
+	flag.Int64Var(&opts.mmapMinSize, "mmap-min-size", 1<<20, "Minimum file size in bytes memory-mapped by -mmap")
+	flag.Var(&opts.expectFiles, "expect-files", "Fail unless the number of files is within MIN:MAX")
+	flag.Var(&opts.expectBytes, "expect-bytes", "Fail unless the number of bytes is within MIN:MAX")
+	flag.Var(&opts.lineRanges, "range", "Include only lines START-END of one file, as 'relative/path:START-END' (repeatable, one per file)")
+	flag.Int64Var(&opts.maxSize, "max-size", 0, "List files larger than N bytes with a note instead of reading them")
+	flag.IntVar(&opts.previewLines, "preview-lines", 0, "Include only the first N lines of each file as a preview")
+	flag.Var(&opts.sampleSections, "sample-sections", "Show only the first, middle and last lines of longer files: N lines each or HEAD,MIDDLE,TAIL")
+	flag.Var(&opts.includes, "include", "Only include files matching these comma-separated globs; append @depth<=N to limit depth (repeatable)")
+	flag.Var(&opts.excludes, "exclude", "Exclude files and directories matching these comma-separated globs, as for -include; @depth is that of the matched directory or file; wins over -include (repeatable)")
+	flag.BoolVar(&opts.includeBinary, "include-binary", false, "Include the content of files that look binary instead of a note")
+	flag.Var(&opts.pathIncludeRe, "path-include-re", "Only include files whose relative path matches this regex (repeatable)")
+	flag.Var(&opts.pathExcludeRe, "path-exclude-re", "Exclude files whose relative path matches this regex (repeatable, wins over includes)")
+	flag.BoolVar(&opts.nonceSeparators, "nonce-separators", false, "Wrap each file in unique per-run START/END separators for reliable splitting")
+	flag.BoolVar(&opts.clipboard, "clipboard", false, "Copy the combined output to the system clipboard")
+	flag.StringVar(&opts.compress, "compress", compressNone, "Compress the output file: none, gzip, or zip")
+	flag.IntVar(&opts.compressionLevel, "compression-level", 6,
+		"Compression level from 1 (fastest) to 9 (smallest output); higher levels trade speed for ratio")
+	flag.StringVar(&opts.postTo, "post-to", "", "Stream the combined output as the body of a POST request to this URL")
+	flag.Var(&opts.postHeaders, "header", "Add a 'Key: Value' header to the -post-to request (repeatable)")
+	flag.Int64Var(&opts.clipboardMaxBytes, "clipboard-max-bytes", 16<<20, "Refuse to copy more than N bytes to the clipboard without -force (0 for no limit)")
+	flag.BoolVar(&opts.force, "force", false, "Proceed despite safety limits such as -clipboard-max-bytes, and let -unpack overwrite existing files")
+	flag.BoolVar(&opts.skipUnreadable, "skip-unreadable", true, "Skip directories that cannot be read due to permissions instead of aborting")
+	flag.IntVar(&opts.readDirBatch, "readdir-batch", 0, "Read directory names N at a time and stat entries only as they are visited, to bound memory on very wide directories (0 reads each directory whole)")
+	flag.IntVar(&opts.maxOpenDirs, "max-open-dirs", 4, "Maximum directory handles held open at once while walking; up to this many subdirectories are listed ahead concurrently")
+	flag.BoolVar(&opts.deterministic, "deterministic", false, "Omit generation and modification times from the output")
+	flag.StringVar(&opts.runID, "run-id", "", "Run ID written to the output header (default: a generated UUID, omitted with -deterministic)")
+	flag.BoolVar(&opts.relativePaths, "relative-paths", false, "Show paths relative to the scanned directory in headers")
+	flag.BoolVar(&opts.commentHeaders, "comment-headers", false, "Write each file's header as comments in the file's own language")
+	flag.StringVar(&opts.commentHeaderFallback, "comment-header-fallback", fallbackPlain,
+		"Header for files with no known comment syntax under -comment-headers: hash (# comments), plain, or none")
+	headerTemplate := flag.String("header-template", "", "Go template for file headers, e.g. '### {{.Path}} ({{.Size}} bytes)'; fields: Label, Path, Submodule, Language, Size, ModTime, SyntaxError")
+	flag.Var(&opts.headerTemplates, "header-template-for", "Header template for files matching an -include style glob, as 'glob=template'; the first match wins over -header-template (repeatable)")
+	flag.BoolVar(&opts.posixPaths, "posix-paths", false, "Show paths in headers with forward slashes on every platform")
+	flag.BoolVar(&opts.lowercasePaths, "lowercase-paths", false, "Lowercase displayed paths and order and dedup them case-insensitively (lossy)")
+	flag.StringVar(&opts.sortMode, "sort", sortPath, "Order files by: path (relative path, lexically), topo (local imports before their importers, then the rest by path) or none (as workers finish)")
+	flag.BoolVar(&opts.diffFriendly, "diff-friendly", false, "Produce stable output for diffing (implies -deterministic, -relative-paths and sorted order)")
+	flag.Int64Var(&opts.mergeSmallUnder, "merge-small-under", 0, "Merge files smaller than N bytes in the same directory under one header")
+	flag.BoolVar(&opts.validateUTF8, "validate-utf8", false, "Report text files containing invalid UTF-8")
+	flag.BoolVar(&opts.strict, "strict", false, "Exit non-zero when validation problems are found")
+	flag.BoolVar(&opts.summaryByDir, "summary-by-dir", false, "Break the summary down by top-level directory")
+	flag.BoolVar(&opts.sizeHistogram, "size-histogram", false, "Add a logarithmic histogram of file sizes to the summary")
+	flag.Var(&replaceFlag{rules: &opts.replaceRules}, "replace", "Replace 'old=new' in file content; escape '=' as '\\=' (repeatable)")
+	flag.Var(&replaceFlag{rules: &opts.replaceRules, regex: true}, "replace-re", "Apply a '/regex/replacement/' substitution to file content (repeatable)")
+	flag.Int64Var(&opts.maxDirBytes, "max-dir-bytes", 0, "Include files from each directory, in order, until they would exceed N bytes")
+	flag.Var(&opts.stripLinePrefix, "strip-line-prefix", "Remove the match of this regex from the start of each line it matches (repeatable)")
+	flag.IntVar(&opts.perLanguageCap, "per-language-cap", 0, "Include at most N files of each detected language")
+	flag.BoolVar(&opts.recurseSubmodules, "recurse-submodules", false, "Include git submodules using each submodule's own ignore rules")
+	flag.BoolVar(&opts.orderByInclude, "order-by-include", false, "Order files by the first -include pattern they match, then by path")
+	flag.BoolVar(&opts.readmeFirst, "readme-first", false, "Place README/index files first within their directory")
+	flag.BoolVar(&opts.citeIndex, "cite-index", false, "Number files in sorted order and list them in an index table at the top")
+	reachableFrom := flag.String("reachable-from", "", "Only include files transitively imported from these comma-separated entry points")
+	flag.BoolVar(&opts.recencyGroups, "recency-groups", false, "Group files under headers by modification time: today, this week, this month, earlier")
+	flag.DurationVar(&opts.skipRecent, "skip-recent", 0, "Skip and report files modified within this long of the run, e.g. 2s, as they may be mid-save")
+	flag.DurationVar(&opts.fileTimeout, "file-timeout", 0, "Give up on files that take longer than this to read, e.g. 5s, and report them as timed out")
+	flag.StringVar(&opts.modifiedWithin, "modified-within", "", "Only include files modified within: today, week, or month")
+	flag.BoolVar(&opts.skipLockfileLike, "skip-lockfile-like", false, "Skip files whose content looks like a generated dependency lockfile")
+	flag.BoolVar(&opts.skipMinified, "skip-minified", false, "Skip files that look like minified assets")
+	flag.Var(opts.routes, "route", "Write files of a category (source, config or docs) to their own output as 'category=path'; others go to -output (repeatable)")
+	flag.IntVar(&opts.shards, "shards", 0, "Write entries to N shard files in parallel, assigned by path hash")
+	flag.BoolVar(&opts.concatShards, "concat-shards", false, "Concatenate shard files into the output in shard order")
+	flag.IntVar(&opts.maxTokens, "max-tokens", 0, "Include only as many files as fit in N estimated tokens, in output order")
+	flag.BoolVar(&opts.fitBudget, "fit-budget", false, "Fill -max-tokens with as many files as possible instead of in output order")
+	flag.StringVar(&opts.overBudget, "over-budget", overBudgetDrop, "What -max-tokens does to files beyond the budget: drop them, or truncate the largest so every file fits")
+	tokenizerName := flag.String("tokenizer", "bytes", "How tokens are counted: bytes (4 bytes per token), or an approximation of the cl100k or o200k encoding")
+	flag.StringVar(&opts.unpackPath, "unpack", "", "Recreate the files of a combined output under -dir instead of combining; paths lose the recorded source directory, or the alias of -dir path=alias")
+	flag.BoolVar(&opts.unpackModTimes, "unpack-mtimes", false, "Restore the modification times recorded in the output when unpacking")
+	flag.BoolVar(&opts.watch, "watch", false, "Keep -output up to date as files under -dir change, rewriting only the sections of changed files where the other options allow")
+	flag.StringVar(&opts.sectionIndex, "section-index", "", "Write the byte range and hash of each file's section in -output to this JSON file, as -watch uses to patch the output in place")
+	flag.BoolVar(&opts.tokenReport, "token-report", false, "Print the tokens of every file and the total to stderr without writing output")
+	flag.IntVar(&opts.chunkTokens, "chunk-tokens", 0, "Also write the output as chunk files of at most N estimated tokens")
+	flag.IntVar(&opts.splitSize, "split-size", 0, "Also write the output as chunk files each holding at most N bytes of it, plus a short chunk header")
+	flag.IntVar(&opts.chunkOverlap, "chunk-overlap", 0, "Number of tokens (bytes with -split-size) each chunk repeats from the end of the previous one")
+	flag.StringVar(&opts.splitBoundary, "split-boundary", boundaryLine, "Where chunks may cut inside a file: line, paragraph, or file (never)")
+	indexNames := flag.String("index-names", "README.md,README,README.txt,README.rst,index.md,index.html",
+		"Comma-separated file names treated as directory index files by -readme-first")
+	flag.BoolVar(&opts.expandIncludes, "expand-includes", false, "Inline files referenced by include directives such as '// @include other.go'")
+	flag.StringVar(&opts.includeDirective, "include-directive", defaultIncludeDirective, "Regex matching an include directive line; the first group is the path")
+	flag.BoolVar(&opts.transcode, "transcode", false, "Convert UTF-16 and Latin-1 files to UTF-8, detecting the encoding per file")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Report per-file details such as the detected encoding of transcoded files")
+	flag.BoolVar(&opts.progress, "progress", false, "Show a progress line with an ETA on stderr while files are processed (terminals only)")
+	flag.BoolVar(&opts.scrubAbsPaths, "scrub-abs-paths", false, "Replace absolute paths under the scanned directory in file content with "+scrubPlaceholder)
+	flag.BoolVar(&opts.stripImports, "strip-imports", false, "Remove import statements from Go, Python, JavaScript and TypeScript files")
+	flag.BoolVar(&opts.redactSecrets, "redact-secrets", false, "Replace detected secrets in file content with [REDACTED]")
+	flag.BoolVar(&opts.reportSecrets, "report-secrets", false, "Report the location of detected secrets without printing them")
+	flag.BoolVar(&opts.failOnSecrets, "fail-on-secrets", false, "Report detected secrets and exit non-zero if any are found")
+	flag.Float64Var(&opts.secretConfig.entropyThreshold, "secret-entropy-threshold", defaultSecretEntropy,
+		"Minimum Shannon entropy (bits/char) for a token to count as a secret; 0 disables entropy detection")
+	flag.IntVar(&opts.secretConfig.minLength, "secret-min-length", defaultSecretMinLength, "Minimum length of tokens checked for entropy")
+	flag.Parse()
+
+	// The alias follows the last '=', unless the whole value names an
+	// existing directory
+	if i := strings.LastIndex(opts.dirPath, "="); i >= 0 {
+		if _, err := os.Stat(opts.dirPath); err != nil {
+			opts.dirPath, opts.dirAlias = opts.dirPath[:i], opts.dirPath[i+1:]
+			if opts.dirPath == "" || opts.dirAlias == "" {
+				return nil, fmt.Errorf("expected -dir path=alias, got %q", opts.dirPath+"="+opts.dirAlias)
+			}
+		}
+	}
+
+	for _, name := range strings.Split(*indexNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.indexNames = append(opts.indexNames, name)
+		}
+	}
+
+	for _, root := range strings.Split(*reachableFrom, ",") {
+		if root = strings.TrimSpace(root); root != "" {
+			opts.reachableFrom = append(opts.reachableFrom, root)
+		}
+	}
+
+	if opts.gitignoreTemplates != "" {
+		for _, name := range strings.Split(opts.gitignoreTemplates, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.templateNames = append(opts.templateNames, name)
+			}
+		}
+
+		var err error
+		opts.templateIgnore, err = compileGitignoreTemplates(opts.templateNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch opts.sortMode {
+	case sortPath:
+		opts.sortOutput = true
+	case sortTopo:
+		// Topological order starts from path order so ties break by path
+		opts.sortOutput = true
+	case sortNone:
+	default:
+		return nil, fmt.Errorf("unknown sort order %q", opts.sortMode)
+	}
+
+	if opts.diffFriendly {
+		// A fresh nonce in the header and every separator would make each
+		// run differ from the last
+		if opts.nonceSeparators {
+			return nil, fmt.Errorf("-diff-friendly cannot be combined with -nonce-separators")
+		}
+		opts.deterministic = true
+		opts.relativePaths = true
+		opts.sortOutput = true
+	}
+
+	// Grouping and reordering need every entry before writing any
+	if opts.mergeSmallUnder > 0 || opts.readmeFirst || opts.perLanguageCap > 0 || opts.maxDirBytes > 0 ||
+		opts.lowercasePaths || opts.citeIndex || len(opts.reachableFrom) > 0 ||
+		opts.recencyGroups || opts.modifiedWithin != "" || opts.maxTokens > 0 || opts.orderByInclude || opts.tokenReport {
+		opts.sortOutput = true
+	}
+
+	if opts.orderByInclude && len(opts.includes) == 0 {
+		return nil, fmt.Errorf("-order-by-include requires -include")
+	}
+
+	// Validation and the token report exercise the whole pipeline but write
+	// nothing, so the default output file is dropped and explicit outputs are
+	// rejected
+	if opts.validate || opts.tokenReport {
+		name := "-validate"
+		if !opts.validate {
+			name = "-token-report"
+		}
+		if opts.clipboard || opts.postTo != "" || opts.chunkTokens > 0 || opts.splitSize > 0 || opts.shards > 1 ||
+			len(opts.routes) > 0 || opts.onChangeExec != "" {
+			return nil, fmt.Errorf("%s writes no output and cannot be combined with -clipboard, -post-to, -chunk-tokens, -split-size, -shards, -route or -on-change-exec", name)
+		}
+		opts.outputPath = ""
+		opts.compress = compressNone
+	}
+
+	switch opts.commentHeaderFallback {
+	case fallbackHash, fallbackPlain, fallbackNone:
+	default:
+		return nil, fmt.Errorf("unknown comment header fallback %q", opts.commentHeaderFallback)
+	}
+
+	if *headerTemplate != "" {
+		tmpl, err := parseHeaderTemplate("header", *headerTemplate)
+		if err != nil {
+			return nil, err
+		}
+		opts.headerTemplate = tmpl
+	}
+
+	if opts.previewLines > 0 && opts.sampleSections.total() > 0 {
+		return nil, fmt.Errorf("-preview-lines and -sample-sections cannot be combined")
+	}
+
+	switch opts.format {
+	case formatText, formatNDJSON, formatJSON, formatXML:
+	case formatMarkdown:
+		// The table of contents lists every file before the first one
+		opts.sortOutput = true
+	default:
+		return nil, fmt.Errorf("unknown output format %q", opts.format)
+	}
+	if opts.jsonWithStats && opts.format != formatJSON && opts.format != formatNDJSON {
+		return nil, fmt.Errorf("-json-with-stats requires -format %s or %s", formatJSON, formatNDJSON)
+	}
+
+	switch opts.compress {
+	case compressNone:
+	case compressGzip, compressZip:
+		switch {
+		case opts.compressionLevel < flate.BestSpeed || opts.compressionLevel > flate.BestCompression:
+			return nil, fmt.Errorf("-compression-level must be between %d and %d", flate.BestSpeed, flate.BestCompression)
+		case opts.outputPath == "":
+			return nil, fmt.Errorf("-compress requires -output")
+		case opts.onChangeExec != "":
+			return nil, fmt.Errorf("-compress cannot be combined with -on-change-exec")
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression mode %q", opts.compress)
+	}
+
+	if opts.watch {
+		switch {
+		case opts.outputPath == "":
+			return nil, fmt.Errorf("-watch requires -output")
+		case opts.validate || opts.tokenReport || opts.unpackPath != "":
+			return nil, fmt.Errorf("-watch cannot be combined with -validate, -token-report or -unpack")
+		}
+	}
+
+	if opts.sectionIndex != "" {
+		if opts.outputPath == "" {
+			return nil, fmt.Errorf("-section-index requires -output")
+		}
+		if conflict := sectionConflict(opts); conflict != "" {
+			return nil, fmt.Errorf("-section-index cannot be combined with %s", conflict)
+		}
+	}
+
+	if opts.unpackModTimes && opts.unpackPath == "" {
+		return nil, fmt.Errorf("-unpack-mtimes requires -unpack")
+	}
+
+	if opts.fitBudget && opts.maxTokens <= 0 {
+		return nil, fmt.Errorf("-fit-budget requires -max-tokens")
+	}
+	switch opts.overBudget {
+	case overBudgetDrop:
+	case overBudgetTruncate:
+		if opts.fitBudget {
+			return nil, fmt.Errorf("-fit-budget only applies to -over-budget %s", overBudgetDrop)
+		}
+	default:
+		return nil, fmt.Errorf("unknown -over-budget mode %q", opts.overBudget)
+	}
+	opts.tokenizer = tokenizers[*tokenizerName]
+	if opts.tokenizer == nil {
+		return nil, fmt.Errorf("unknown tokenizer %q (available: %s)", *tokenizerName, tokenizerNames())
+	}
+
+	if opts.chunkTokens > 0 && opts.splitSize > 0 {
+		return nil, fmt.Errorf("-chunk-tokens and -split-size cannot be combined")
+	}
+	if budget, _ := chunkBudget(opts); budget > 0 {
+		name := "-chunk-tokens"
+		if opts.splitSize > 0 {
+			name = "-split-size"
+		}
+		switch {
+		case opts.chunkOverlap < 0 || opts.chunkOverlap >= budget:
+			return nil, fmt.Errorf("-chunk-overlap must be between 0 and %s", name)
+		case opts.format != formatText:
+			return nil, fmt.Errorf("%s requires -format %s", name, formatText)
+		case opts.outputPath == "":
+			return nil, fmt.Errorf("%s requires -output to name the chunk files", name)
+		}
+
+		switch opts.splitBoundary {
+		case boundaryLine, boundaryParagraph, boundaryFile:
+		default:
+			return nil, fmt.Errorf("unknown split boundary %q", opts.splitBoundary)
+		}
+	}
+
+	if opts.onChangeExec != "" {
+		if opts.outputPath == "" || opts.format != formatText {
+			return nil, fmt.Errorf("-on-change-exec requires -output with -format %s", formatText)
+		}
+		opts.treeHash = true
+	}
+
+	if opts.modifiedWithin != "" {
+		if i := recencyBucketIndex(opts.modifiedWithin); i < 0 || i == len(recencyBuckets)-1 {
+			return nil, fmt.Errorf("-modified-within must be today, week, or month")
+		}
+	}
+
+	if opts.fileTimeout < 0 {
+		return nil, fmt.Errorf("-file-timeout must not be negative")
+	}
+
+	switch {
+	case opts.clearCache && opts.cacheDir == "":
+		return nil, fmt.Errorf("-clear-cache requires -cache-dir")
+	case opts.cacheDir != "" && opts.expandIncludes:
+		// Included files can change without the including file changing
+		return nil, fmt.Errorf("-cache-dir cannot be combined with -expand-includes")
+	}
+
+	if len(opts.routes) > 0 {
+		switch {
+		case opts.format != formatText:
+			return nil, fmt.Errorf("-route requires -format %s", formatText)
+		case opts.shards > 1 || opts.chunkTokens > 0 || opts.splitSize > 0 || opts.mergeSmallUnder > 0:
+			return nil, fmt.Errorf("-route cannot be combined with -shards, -chunk-tokens, -split-size or -merge-small-under")
+		}
+		for _, path := range opts.routes {
+			if opts.outputPath != "" && filepath.Clean(path) == filepath.Clean(opts.outputPath) {
+				return nil, fmt.Errorf("-route output %s is the same as -output", path)
+			}
+		}
+	}
+
+	if opts.shards > 1 {
+		switch {
+		case opts.format != formatText:
+			return nil, fmt.Errorf("-shards requires -format %s", formatText)
+		case opts.outputPath == "":
+			return nil, fmt.Errorf("-shards requires -output to name the shard files")
+		case opts.clipboard || opts.chunkTokens > 0 || opts.splitSize > 0 || opts.mergeSmallUnder > 0 || opts.recencyGroups:
+			return nil, fmt.Errorf("-shards cannot be combined with -clipboard, -chunk-tokens, -split-size, -merge-small-under or -recency-groups")
+		}
+	}
+
+	// Transcoding runs first so every other transform works on UTF-8
+	if opts.transcode {
+		opts.transforms = append(opts.transforms, transcodeTransform)
+	}
+	// Excerpts are cut before anything else changes line numbers
+	if len(opts.lineRanges.byPath) > 0 {
+		opts.transforms = append(opts.transforms, opts.lineRanges.transform)
+	}
+	// Expansion runs next so later transforms see the included content too
+	if opts.expandIncludes {
+		expander, err := newIncludeExpander(opts.includeDirective, opts)
+		if err != nil {
+			return nil, err
+		}
+		opts.includeExpander = expander
+		opts.transforms = append(opts.transforms, expander.transform)
+	}
+	if len(opts.stripLinePrefix) > 0 {
+		opts.transforms = append(opts.transforms, stripLinePrefixTransform(opts.stripLinePrefix))
+	}
+	if opts.scrubAbsPaths {
+		scrub, err := scrubAbsPathsTransform(opts.dirPath)
+		if err != nil {
+			return nil, err
+		}
+		opts.transforms = append(opts.transforms, scrub)
+	}
+	if len(opts.replaceRules) > 0 {
+		opts.transforms = append(opts.transforms, replaceTransform(opts.replaceRules))
+	}
+	if opts.stripImports {
+		opts.transforms = append(opts.transforms, stripImportsTransform)
+	}
+	// Secret detection runs last so it sees the content that will be written
+	if opts.redactSecrets || opts.reportSecrets || opts.failOnSecrets {
+		opts.transforms = append(opts.transforms, secretsTransform(opts.secretConfig, opts.redactSecrets))
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody caps how much of a failed response is quoted in the error
+const maxErrorBody = 512
+
+// headerList is a repeatable flag of 'Key: Value' request headers
+type headerList []string
+
+func (l *headerList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ", ")
+}
+
+func (l *headerList) Set(value string) error {
+	key, _, found := strings.Cut(value, ":")
+	if !found || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("expected 'Key: Value', got %q", value)
+	}
+	*l = append(*l, value)
+	return nil
+}
+
+// httpPoster streams everything written to it as the chunked body of a POST
+// request, so the combined output never has to be held in memory. Writes
+// after the request has failed are discarded so other outputs can finish;
+// the failure is reported by Close.
+type httpPoster struct {
+	pipe   *io.PipeWriter
+	result chan error
+	status string
+	failed error
+}
+
+func newHTTPPoster(url string, headers []string, contentType string) (*httpPoster, error) {
+	body, pipe := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for _, header := range headers {
+		key, value, _ := strings.Cut(header, ":")
+		req.Header.Set(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	p := &httpPoster{pipe: pipe, result: make(chan error, 1)}
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			body.CloseWithError(err)
+			p.result <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		// The server may answer before reading the whole body; stop the
+		// writer rather than letting it block on a pipe nobody drains
+		p.status = resp.Status
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			msg, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+			err := fmt.Errorf("server responded %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+			body.CloseWithError(err)
+			p.result <- err
+			return
+		}
+		io.Copy(io.Discard, resp.Body)
+		body.Close()
+		p.result <- nil
+	}()
+	return p, nil
+}
+
+func (p *httpPoster) Write(data []byte) (int, error) {
+	if p.failed == nil {
+		if _, err := p.pipe.Write(data); err != nil {
+			p.failed = err
+		}
+	}
+	return len(data), nil
+}
+
+// Close ends the request body and waits for the response, returning its
+// status line or the reason the request failed
+func (p *httpPoster) Close() (string, error) {
+	p.pipe.Close()
+	if err := <-p.result; err != nil {
+		return "", err
+	}
+	return p.status, nil
+}
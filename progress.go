@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval throttles how often the progress line is redrawn
+	progressInterval = 250 * time.Millisecond
+
+	// progressWindow is how far back the processing rate is measured, so the
+	// ETA follows the current pace rather than the average of the whole run
+	progressWindow = 10 * time.Second
+)
+
+type progressSample struct {
+	at   time.Time
+	done int
+}
+
+// progressMeter draws a single self-updating progress line on stderr. The
+// total number of paths is only known once the walk has finished; until
+// then the ETA is shown as unknown. All methods are safe on a nil meter,
+// which is what newProgressMeter returns when stderr is not a terminal.
+type progressMeter struct {
+	mu       sync.Mutex
+	out      *os.File
+	found    int
+	done     int
+	walked   bool
+	samples  []progressSample
+	drawn    time.Time
+	rendered bool
+}
+
+// newProgressMeter returns a meter writing to stderr, or nil if stderr is not
+// a terminal
+func newProgressMeter() *progressMeter {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressMeter{out: os.Stderr}
+}
+
+// add records n more paths found by the walk
+func (p *progressMeter) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.found += n
+	p.mu.Unlock()
+}
+
+// walkDone records that the walk has finished, fixing the total
+func (p *progressMeter) walkDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.walked = true
+	p.mu.Unlock()
+}
+
+// advance records one path handed to a worker and redraws the line if the
+// last redraw is old enough
+func (p *progressMeter) advance() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	now := time.Now()
+	if now.Sub(p.drawn) < progressInterval {
+		return
+	}
+	p.drawn = now
+
+	p.samples = append(p.samples, progressSample{at: now, done: p.done})
+	for len(p.samples) > 1 && now.Sub(p.samples[0].at) > progressWindow {
+		p.samples = p.samples[1:]
+	}
+
+	fmt.Fprintf(p.out, "\r\033[K%s", p.line(now))
+	p.rendered = true
+}
+
+// line formats the progress line from the samples in the window
+func (p *progressMeter) line(now time.Time) string {
+	var rate float64
+	if first := p.samples[0]; now.Sub(first.at) > 0 {
+		rate = float64(p.done-first.done) / now.Sub(first.at).Seconds()
+	}
+
+	eta := "unknown"
+	if p.walked && rate > 0 {
+		remaining := time.Duration(float64(p.found-p.done) / rate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	if p.walked {
+		return fmt.Sprintf("Progress: %d/%d paths, %.1f/s, ETA %s", p.done, p.found, rate, eta)
+	}
+	return fmt.Sprintf("Progress: %d paths, %.1f/s, ETA %s", p.done, rate, eta)
+}
+
+// finish clears the progress line so later output starts on a clean line
+func (p *progressMeter) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.rendered {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.rendered = false
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// jsResolveExts are tried in order when a relative JavaScript or TypeScript
+// specifier omits the file extension
+var jsResolveExts = []string{".ts", ".tsx", ".d.ts", ".js", ".jsx", ".mjs", ".cjs", ".mts", ".cts"}
+
+// importGraph resolves import specifiers to files among the collected
+// entries. Only local files can be resolved; standard library and third-party
+// imports simply have no match.
+type importGraph struct {
+	files      map[string]*FileEntry
+	goPackages map[string][]string
+	goModule   string
+}
+
+func newImportGraph(entries []*FileEntry, dirPath string) *importGraph {
+	g := &importGraph{
+		files:      make(map[string]*FileEntry),
+		goPackages: make(map[string][]string),
+		goModule:   goModulePath(dirPath),
+	}
+	for _, entry := range entries {
+		rel := filepath.ToSlash(entry.relPath)
+		g.files[rel] = entry
+		if detectLanguage(rel) == "go" && !strings.HasSuffix(rel, "_test.go") {
+			dir := path.Dir(rel)
+			g.goPackages[dir] = append(g.goPackages[dir], rel)
+		}
+	}
+	return g
+}
+
+// goModulePath returns the module path declared in dir/go.mod, if any
+func goModulePath(dir string) string {
+	data, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if module, found := strings.CutPrefix(strings.TrimSpace(line), "module "); found {
+			return strings.Trim(strings.TrimSpace(module), `"`)
+		}
+	}
+	return ""
+}
+
+// deps returns the files the file at rel depends on. Go files also depend on
+// the rest of their package, since a package only builds as a whole.
+func (g *importGraph) deps(rel string) []string {
+	entry := g.files[rel]
+	lang := detectLanguage(rel)
+
+	var deps []string
+	if lang == "go" {
+		deps = append(deps, g.goPackages[path.Dir(rel)]...)
+	}
+	for _, spec := range entry.imports {
+		deps = append(deps, g.resolve(rel, lang, spec)...)
+	}
+	return deps
+}
+
+// resolve maps an import specifier in the file at from to local files
+func (g *importGraph) resolve(from, lang, spec string) []string {
+	var candidates []string
+	switch lang {
+	case "go":
+		if g.goModule == "" {
+			return nil
+		}
+		if spec == g.goModule {
+			return g.goPackages["."]
+		}
+		if dir, found := strings.CutPrefix(spec, g.goModule+"/"); found {
+			return g.goPackages[dir]
+		}
+		return nil
+	case "javascript", "typescript":
+		if !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+			return nil
+		}
+		base := path.Join(path.Dir(from), spec)
+		candidates = append(candidates, base)
+		// TypeScript sources are often imported by their compiled .js name
+		if stem, found := strings.CutSuffix(base, ".js"); found {
+			candidates = append(candidates, stem+".ts", stem+".tsx")
+		}
+		for _, ext := range jsResolveExts {
+			candidates = append(candidates, base+ext, base+"/index"+ext)
+		}
+	case "python":
+		dots := len(spec) - len(strings.TrimLeft(spec, "."))
+		rest := strings.ReplaceAll(spec[dots:], ".", "/")
+
+		// Absolute imports are tried from the scanned root and then from the
+		// importing file's directory, as when it is run as a script
+		bases := []string{".", path.Dir(from)}
+		if dots > 0 {
+			base := path.Dir(from)
+			for i := 1; i < dots; i++ {
+				base = path.Dir(base)
+			}
+			bases = []string{base}
+		}
+		for _, base := range bases {
+			module := path.Join(base, rest)
+			candidates = append(candidates, module+".py", module+".pyi", path.Join(module, "__init__.py"))
+		}
+	}
+
+	for _, candidate := range candidates {
+		if _, ok := g.files[candidate]; ok {
+			return []string{candidate}
+		}
+	}
+	return nil
+}
+
+// filterReachable keeps the entries transitively imported from the root
+// paths, preserving their order. Roots that are not among the entries are
+// returned as missing.
+func filterReachable(entries []*FileEntry, roots []string, dirPath string) (kept, dropped []*FileEntry, missing []string) {
+	g := newImportGraph(entries, dirPath)
+
+	reached := make(map[string]bool)
+	var queue []string
+	for _, root := range roots {
+		rel := path.Clean(filepath.ToSlash(root))
+		if _, ok := g.files[rel]; !ok {
+			missing = append(missing, root)
+			continue
+		}
+		if !reached[rel] {
+			reached[rel] = true
+			queue = append(queue, rel)
+		}
+	}
+
+	for len(queue) > 0 {
+		rel := queue[0]
+		queue = queue[1:]
+		for _, dep := range g.deps(rel) {
+			if !reached[dep] {
+				reached[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if reached[filepath.ToSlash(entry.relPath)] {
+			kept = append(kept, entry)
+		} else {
+			dropped = append(dropped, entry)
+		}
+	}
+	return kept, dropped, missing
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// recencyBuckets are the groups used by -recency-groups, newest first. The
+// names double as the values accepted by -modified-within.
+var recencyBuckets = []struct {
+	name  string
+	title string
+}{
+	{"today", "Modified Today"},
+	{"week", "Modified This Week"},
+	{"month", "Modified This Month"},
+	{"older", "Modified Earlier"},
+}
+
+// recencyBucket returns the index in recencyBuckets for a file modified at
+// mod: since midnight, within 7 days, within 30 days, or earlier
+func recencyBucket(mod, now time.Time) int {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	age := now.Sub(mod)
+	switch {
+	case !mod.Before(midnight):
+		return 0
+	case age < 7*24*time.Hour:
+		return 1
+	case age < 30*24*time.Hour:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// recencyBucketIndex returns the index of the named bucket, or -1
+func recencyBucketIndex(name string) int {
+	for i, bucket := range recencyBuckets {
+		if bucket.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// filterRecent keeps the entries modified within the named bucket or a
+// newer one, preserving their order
+func filterRecent(entries []*FileEntry, within string, now time.Time) (kept, dropped []*FileEntry) {
+	limit := recencyBucketIndex(within)
+	for _, entry := range entries {
+		if recencyBucket(entry.info.ModTime(), now) <= limit {
+			kept = append(kept, entry)
+		} else {
+			dropped = append(dropped, entry)
+		}
+	}
+	return kept, dropped
+}
+
+// planRecencySections splits sorted entries into recency buckets, newest
+// first, and plans each bucket's output under its own section header.
+// Entries keep their sorted order within a bucket and small files are only
+// merged with others from the same bucket.
+func planRecencySections(entries []*FileEntry, mergeLimit int64, now time.Time) []outputItem {
+	buckets := make([][]*FileEntry, len(recencyBuckets))
+	for _, entry := range entries {
+		i := recencyBucket(entry.info.ModTime(), now)
+		buckets[i] = append(buckets[i], entry)
+	}
+
+	var items []outputItem
+	for i, bucket := range buckets {
+		if len(bucket) == 0 {
+			continue
+		}
+		items = append(items, outputItem{section: recencyBuckets[i].title})
+		items = append(items, planMerges(bucket, mergeLimit)...)
+	}
+	return items
+}
+
+func writeSectionHeader(out io.Writer, title string) error {
+	_, err := fmt.Fprintf(out, "\n## %s\n", title)
+	return err
+}
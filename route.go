@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// File categories used by -route
+const (
+	categorySource = "source"
+	categoryConfig = "config"
+	categoryDocs   = "docs"
+)
+
+var routeCategories = []string{categorySource, categoryConfig, categoryDocs}
+
+// languageCategory maps detected languages that are not source code to
+// their category; every other detected language counts as source
+var languageCategory = map[string]string{
+	"markdown":   categoryDocs,
+	"json":       categoryConfig,
+	"yaml":       categoryConfig,
+	"toml":       categoryConfig,
+	"ini":        categoryConfig,
+	"xml":        categoryConfig,
+	"makefile":   categoryConfig,
+	"dockerfile": categoryConfig,
+}
+
+// docsExts and configExts cover formats detectLanguage does not know
+var (
+	docsExts   = []string{".txt", ".rst", ".adoc", ".org"}
+	configExts = []string{".cfg", ".conf", ".env", ".properties", ".lock", ".mod", ".sum"}
+)
+
+// docsNames are extensionless documentation files, matched by prefix
+var docsNames = []string{"readme", "license", "licence", "changelog", "contributing", "authors", "notice"}
+
+// classifyFile returns the category of the file at relPath, or an empty
+// string when it fits none of them
+func classifyFile(relPath string) string {
+	if lang := detectLanguage(relPath); lang != "" {
+		if category, ok := languageCategory[lang]; ok {
+			return category
+		}
+		return categorySource
+	}
+
+	base := strings.ToLower(filepath.Base(relPath))
+	ext := filepath.Ext(base)
+	switch {
+	case slices.Contains(docsExts, ext):
+		return categoryDocs
+	case slices.Contains(configExts, ext):
+		return categoryConfig
+	case ext == "" && slices.ContainsFunc(docsNames, func(name string) bool { return strings.HasPrefix(base, name) }):
+		return categoryDocs
+	case strings.HasPrefix(base, ".") && ext == base:
+		// Dotfiles such as .gitignore and .editorconfig configure tools
+		return categoryConfig
+	}
+	return ""
+}
+
+// routeList is a repeatable flag of 'category=output' mappings
+type routeList map[string]string
+
+func (l routeList) String() string {
+	var routes []string
+	for _, category := range routeCategories {
+		if path, ok := l[category]; ok {
+			routes = append(routes, category+"="+path)
+		}
+	}
+	return strings.Join(routes, ",")
+}
+
+func (l routeList) Set(value string) error {
+	category, path, found := strings.Cut(value, "=")
+	category = strings.TrimSpace(category)
+	if !found || path == "" {
+		return fmt.Errorf("expected category=output, got %q", value)
+	}
+	if !slices.Contains(routeCategories, category) {
+		return fmt.Errorf("unknown category %q (expected %s)", category, strings.Join(routeCategories, ", "))
+	}
+	if _, ok := l[category]; ok {
+		return fmt.Errorf("category %q is routed more than once", category)
+	}
+	l[category] = path
+	return nil
+}
+
+// router writes each routed file to its category's output. Categories routed
+// to the same path share one file. It is only used from the goroutine that
+// writes output.
+type router struct {
+	byCategory map[string]*os.File
+	files      map[string]*os.File
+	paths      []string
+}
+
+// newRouter creates the routed outputs and writes header to each
+func newRouter(routes routeList, header string) (*router, error) {
+	r := &router{
+		byCategory: make(map[string]*os.File),
+		files:      make(map[string]*os.File),
+	}
+	for _, category := range routeCategories {
+		path, ok := routes[category]
+		if !ok {
+			continue
+		}
+
+		file, ok := r.files[path]
+		if !ok {
+			var err error
+			file, err = os.Create(path)
+			if err != nil {
+				r.close()
+				return nil, err
+			}
+			if _, err := io.WriteString(file, header); err != nil {
+				file.Close()
+				r.close()
+				return nil, err
+			}
+			r.files[path] = file
+			r.paths = append(r.paths, path)
+		}
+		r.byCategory[category] = file
+	}
+	return r, nil
+}
+
+// target returns the output for entry, or nil if its category is not routed
+// and it belongs in the default output
+func (r *router) target(entry *FileEntry) io.Writer {
+	if file, ok := r.byCategory[classifyFile(entry.relPath)]; ok {
+		return file
+	}
+	return nil
+}
+
+func (r *router) close() error {
+	var firstErr error
+	for _, path := range r.paths {
+		if err := r.files[path].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
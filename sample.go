@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sampleSpec is the number of lines kept from the head, middle and tail of
+// files sampled by -sample-sections
+type sampleSpec struct {
+	head, middle, tail int
+}
+
+func (s *sampleSpec) String() string {
+	if s == nil || s.total() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d,%d,%d", s.head, s.middle, s.tail)
+}
+
+// Set accepts either a single count used for all three sections or separate
+// HEAD,MIDDLE,TAIL counts
+func (s *sampleSpec) Set(value string) error {
+	parts := strings.Split(value, ",")
+	if len(parts) != 1 && len(parts) != 3 {
+		return fmt.Errorf("expected N or HEAD,MIDDLE,TAIL, got %q", value)
+	}
+
+	counts := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid line count %q", part)
+		}
+		counts[i] = n
+	}
+
+	if len(counts) == 1 {
+		*s = sampleSpec{counts[0], counts[0], counts[0]}
+	} else {
+		*s = sampleSpec{counts[0], counts[1], counts[2]}
+	}
+	return nil
+}
+
+func (s sampleSpec) total() int {
+	return s.head + s.middle + s.tail
+}
+
+// lineStart returns the byte offset at which line k (counting from 0) of
+// content starts, or len(content) if it has fewer lines
+func lineStart(content []byte, k int) int {
+	offset := 0
+	for ; k > 0; k-- {
+		idx := bytes.IndexByte(content[offset:], '\n')
+		if idx < 0 {
+			return len(content)
+		}
+		offset += idx + 1
+	}
+	return offset
+}
+
+// sampleSections keeps the configured head, middle and tail lines of content
+// and replaces the gaps between them with omission markers. Content with no
+// more lines than the sections cover is returned unchanged.
+func sampleSections(content []byte, spec sampleSpec) []byte {
+	lines := bytes.Count(content, []byte("\n"))
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+	if lines <= spec.total() {
+		return content
+	}
+
+	// Each section is a half-open range of line numbers. The middle one is
+	// centered but kept clear of the head and tail so no line is written
+	// twice or out of order.
+	type section struct{ start, end int }
+	midStart := min(max(spec.head, (lines-spec.middle)/2), lines-spec.tail-spec.middle)
+	midEnd := midStart + spec.middle
+	sections := []section{
+		{0, spec.head},
+		{midStart, midEnd},
+		{max(lines-spec.tail, midEnd), lines},
+	}
+
+	var out bytes.Buffer
+	next := 0
+	for _, sec := range sections {
+		if sec.start == sec.end {
+			continue
+		}
+		if omitted := sec.start - next; omitted > 0 {
+			fmt.Fprintf(&out, "… [%d lines omitted]\n", omitted)
+		}
+		out.Write(content[lineStart(content, sec.start):lineStart(content, sec.end)])
+		next = sec.end
+	}
+	if omitted := lines - next; omitted > 0 {
+		fmt.Fprintf(&out, "… [%d lines omitted]\n", omitted)
+	}
+
+	// The last line of the tail may lack a trailing newline
+	if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// scrubPlaceholder replaces the scan root in absolute paths found in content
+const scrubPlaceholder = "<root>"
+
+// rootPathPattern builds a regex matching root as it may be spelled in file
+// content: with forward slashes, backslashes or doubled backslashes as found
+// in escaped string literals. Paths with a drive letter or UNC prefix match
+// case-insensitively, as Windows compares them.
+func rootPathPattern(root string) *regexp.Regexp {
+	root = filepath.ToSlash(root)
+	volume := filepath.VolumeName(root)
+
+	var segments []string
+	for _, segment := range strings.Split(strings.TrimPrefix(root, volume), "/") {
+		if segment != "" {
+			segments = append(segments, regexp.QuoteMeta(segment))
+		}
+	}
+
+	const sep = `(?:/|\\\\|\\)`
+	pattern := sep + strings.Join(segments, sep)
+	if volume != "" {
+		prefix := regexp.QuoteMeta(volume)
+		if strings.HasPrefix(volume, "//") {
+			prefix = sep + sep + regexp.QuoteMeta(strings.TrimPrefix(volume, "//"))
+		}
+		pattern = "(?i)" + prefix + pattern
+	}
+	return regexp.MustCompile(pattern)
+}
+
+// isPathNameByte reports whether b can be part of a file or directory name in
+// the sense that matters for boundaries: a root followed by one of these
+// names a different directory, such as /src/app2 next to /src/app
+func isPathNameByte(b byte) bool {
+	return b == '_' || b == '-' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= 0x80
+}
+
+// underRoot reports whether the match at content[start:end] is the root
+// itself rather than part of a longer or different path
+func underRoot(content []byte, start, end int) bool {
+	if start > 0 {
+		if b := content[start-1]; isPathNameByte(b) || b == '/' || b == '\\' || b == '.' || b == ':' {
+			return false
+		}
+	}
+	if end < len(content) {
+		b := content[end]
+		if isPathNameByte(b) {
+			return false
+		}
+		// A dot continues the name, as in app.bak, unless it ends a sentence
+		if b == '.' && end+1 < len(content) && isPathNameByte(content[end+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// scrubAbsPathsTransform replaces the scan root in absolute paths found in
+// file content with a placeholder, leaving the rest of each path in place.
+// Both the root as given and its symlink-resolved form are recognized.
+func scrubAbsPathsTransform(dirPath string) (Transform, error) {
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{root}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil && resolved != root {
+		roots = append(roots, resolved)
+	}
+
+	var patterns []*regexp.Regexp
+	for _, root := range roots {
+		// Scanning a filesystem root would rewrite every absolute path
+		if strings.Trim(filepath.ToSlash(strings.TrimPrefix(root, filepath.VolumeName(root))), "/") == "" {
+			continue
+		}
+		patterns = append(patterns, rootPathPattern(root))
+	}
+
+	return func(entry *FileEntry, content []byte) ([]byte, error) {
+		for _, re := range patterns {
+			matches := re.FindAllIndex(content, -1)
+			if len(matches) == 0 {
+				continue
+			}
+
+			out := make([]byte, 0, len(content))
+			last := 0
+			for _, m := range matches {
+				if !underRoot(content, m[0], m[1]) {
+					continue
+				}
+				out = append(out, content[last:m[0]]...)
+				out = append(out, scrubPlaceholder...)
+				last = m[1]
+			}
+			content = append(out, content[last:]...)
+		}
+		return content, nil
+	}, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// secretPattern is a well-known credential format. When group is non-zero
+// only that capture group is treated as the secret.
+type secretPattern struct {
+	kind  string
+	re    *regexp.Regexp
+	group int
+}
+
+var secretPatterns = []secretPattern{
+	{kind: "aws-access-key", re: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{kind: "github-token", re: regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`)},
+	{kind: "slack-token", re: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{kind: "private-key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{
+		kind:  "credential-assignment",
+		re:    regexp.MustCompile(`(?i)\b(?:api[_-]?key|secret|token|passw(?:or)?d)\w*["']?\s*[:=]\s*["']([^"'\s]{8,})["']`),
+		group: 1,
+	},
+}
+
+// Defaults for entropy-based detection. A threshold of 4.5 bits per character
+// catches random base64-like tokens of about 32 characters and more while
+// leaving ordinary identifiers and hex digests alone; values much below 4.0
+// start flagging long camelCase names and hashes.
+const (
+	defaultSecretEntropy   = 4.5
+	defaultSecretMinLength = 24
+	secretEntropyWindow    = 64
+)
+
+// secretConfig controls secret detection
+type secretConfig struct {
+	entropyThreshold float64
+	minLength        int
+}
+
+// secretFinding is a detected secret's location within content
+type secretFinding struct {
+	kind       string
+	start, end int
+	line       int
+}
+
+// secretTokenRe matches runs of characters that typically make up keys and
+// tokens, the candidates for entropy-based detection
+var secretTokenRe = regexp.MustCompile(`[A-Za-z0-9+/=_\-]+`)
+
+// shannonEntropy returns the Shannon entropy of s in bits per byte
+func shannonEntropy(s []byte) float64 {
+	if len(s) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, c := range s {
+		counts[c]++
+	}
+
+	entropy := 0.0
+	n := float64(len(s))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// maxWindowEntropy returns the highest entropy of any window over token, so a
+// secret embedded in a longer run of token characters still stands out
+func maxWindowEntropy(token []byte) float64 {
+	if len(token) <= secretEntropyWindow {
+		return shannonEntropy(token)
+	}
+
+	best := 0.0
+	for start := 0; start+secretEntropyWindow <= len(token); start += secretEntropyWindow / 4 {
+		best = max(best, shannonEntropy(token[start:start+secretEntropyWindow]))
+	}
+	return best
+}
+
+// findSecrets returns the secrets detected in content, ordered by position
+// with overlapping findings merged
+func findSecrets(content []byte, cfg secretConfig) []secretFinding {
+	var findings []secretFinding
+
+	for _, pattern := range secretPatterns {
+		for _, m := range pattern.re.FindAllSubmatchIndex(content, -1) {
+			start, end := m[2*pattern.group], m[2*pattern.group+1]
+			findings = append(findings, secretFinding{kind: pattern.kind, start: start, end: end})
+		}
+	}
+
+	if cfg.entropyThreshold > 0 {
+		for _, m := range secretTokenRe.FindAllIndex(content, -1) {
+			token := content[m[0]:m[1]]
+			if len(token) < cfg.minLength || !bytes.ContainsAny(token, "0123456789") {
+				continue
+			}
+			if maxWindowEntropy(token) >= cfg.entropyThreshold {
+				findings = append(findings, secretFinding{kind: "high-entropy-string", start: m[0], end: m[1]})
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].start < findings[j].start
+	})
+
+	var merged []secretFinding
+	for _, f := range findings {
+		if n := len(merged); n > 0 && f.start < merged[n-1].end {
+			merged[n-1].end = max(merged[n-1].end, f.end)
+			continue
+		}
+		f.line = bytes.Count(content[:f.start], []byte("\n")) + 1
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// redactSecrets replaces each finding in content with a redaction marker
+func redactSecrets(content []byte, findings []secretFinding) []byte {
+	if len(findings) == 0 {
+		return content
+	}
+
+	out := make([]byte, 0, len(content))
+	last := 0
+	for _, f := range findings {
+		out = append(out, content[last:f.start]...)
+		out = append(out, "[REDACTED]"...)
+		last = f.end
+	}
+	return append(out, content[last:]...)
+}
+
+// secretsTransform records the secrets found in each text file on the entry
+// and, when redact is set, replaces them in the content
+func secretsTransform(cfg secretConfig, redact bool) Transform {
+	return func(entry *FileEntry, content []byte) ([]byte, error) {
+		if isBinary(content) {
+			return content, nil
+		}
+
+		entry.secrets = findSecrets(content, cfg)
+		if redact {
+			return redactSecrets(content, entry.secrets), nil
+		}
+		return content, nil
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestShannonEntropy(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"", 0},
+		{"aaaaaaaa", 0},
+		{"ab", 1},
+		{"abababab", 1},
+		{"abcd", 2},
+		{"0123456789abcdef", 4},
+		{"aab", -(2.0/3*math.Log2(2.0/3) + 1.0/3*math.Log2(1.0/3))},
+	}
+	for _, tt := range tests {
+		if got := shannonEntropy([]byte(tt.input)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("shannonEntropy(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestMaxWindowEntropy(t *testing.T) {
+	// Tokens up to a window long are measured whole
+	if got := maxWindowEntropy([]byte("abcd")); got != 2 {
+		t.Errorf("maxWindowEntropy(abcd) = %v, want 2", got)
+	}
+	if got := maxWindowEntropy([]byte(strings.Repeat("ab", 100))); got != 1 {
+		t.Errorf("maxWindowEntropy of a repetitive token = %v, want 1", got)
+	}
+
+	// A random stretch inside a long repetitive token stands out in its
+	// window even though the token as a whole looks ordinary
+	random := "q8Zr2LmX0vTbN4yKcW7hJsPd1gFe9uAoR3tYxBnMi6lQkVz5HwCjUp"
+	token := []byte(strings.Repeat("a", 200) + random + strings.Repeat("a", 200))
+	if whole := shannonEntropy(token); whole >= defaultSecretEntropy {
+		t.Fatalf("test token has entropy %v as a whole, want it below %v", whole, defaultSecretEntropy)
+	}
+	if got := maxWindowEntropy(token); got < defaultSecretEntropy {
+		t.Errorf("maxWindowEntropy of a token with an embedded secret = %v, want at least %v", got, defaultSecretEntropy)
+	}
+}
+
+func TestFindSecretsEntropy(t *testing.T) {
+	cfg := secretConfig{entropyThreshold: defaultSecretEntropy, minLength: defaultSecretMinLength}
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"random base64 token", "key = q8Zr2LmX0vTbN4yKcW7hJsPd1gFe9uAoR3tYxBnMi6lQ\n", 1},
+		{"camelCase identifier", "func handleIncomingWebhookRequestForUser2() {}\n", 0},
+		{"sha1 hex digest", "commit 3f786850e387550fdab836ed7e6dc881de23001b\n", 0},
+		{"short random token", "id = q8Zr2LmX0vTbN4y\n", 0},
+		{"no digits", "word = qZrLmXvTbNyKcWhJsPdgFeuAoRtYxBnMilQkVz\n", 0},
+	}
+	for _, tt := range tests {
+		if got := len(findSecrets([]byte(tt.content), cfg)); got != tt.want {
+			t.Errorf("findSecrets(%s) found %d secrets, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// A threshold of zero turns entropy detection off
+	if got := findSecrets([]byte(tests[0].content), secretConfig{minLength: defaultSecretMinLength}); len(got) != 0 {
+		t.Errorf("findSecrets with entropy detection off found %d secrets, want 0", len(got))
+	}
+}
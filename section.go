@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// sectionIndex records where each file's section lies in a text -output, so
+// -watch can rewrite the sections of changed files in place instead of
+// regenerating the whole output. Size and ModTime are those of the output
+// the index describes; an output that no longer matches them is not patched.
+type sectionIndex struct {
+	Size     int64          `json:"size"`
+	ModTime  int64          `json:"mtime_ns"`
+	Nonce    string         `json:"nonce,omitempty"`
+	Sections []sectionRange `json:"sections"`
+}
+
+// sectionRange is the byte range [Start, End) of one file's section in the
+// output and the SHA-256 of those bytes
+type sectionRange struct {
+	Path  string `json:"path"`
+	Start int64  `json:"start"`
+	End   int64  `json:"end"`
+	Hash  string `json:"hash"`
+}
+
+// sectionConflict returns the option that makes a file's section depend on
+// other files, or sends the output somewhere that patching -output would not
+// reach, or "" when every section depends on its own file alone
+func sectionConflict(opts *Options) string {
+	switch {
+	case opts.format != formatText:
+		return "-format " + opts.format
+	case opts.treeHash:
+		return "-tree-hash"
+	case opts.manifest:
+		return "-manifest"
+	case opts.onChangeExec != "":
+		return "-on-change-exec"
+	case opts.compress != compressNone:
+		return "-compress"
+	case opts.clipboard:
+		return "-clipboard"
+	case opts.postTo != "":
+		return "-post-to"
+	case opts.shards > 1:
+		return "-shards"
+	case len(opts.routes) > 0:
+		return "-route"
+	case opts.chunkTokens > 0:
+		return "-chunk-tokens"
+	case opts.splitSize > 0:
+		return "-split-size"
+	case opts.mergeSmallUnder > 0:
+		return "-merge-small-under"
+	case opts.citeIndex:
+		return "-cite-index"
+	case opts.recencyGroups:
+		return "-recency-groups"
+	case opts.modifiedWithin != "":
+		return "-modified-within"
+	case opts.perLanguageCap > 0:
+		return "-per-language-cap"
+	case opts.maxDirBytes > 0:
+		return "-max-dir-bytes"
+	case opts.maxTokens > 0:
+		return "-max-tokens"
+	case opts.lowercasePaths:
+		return "-lowercase-paths"
+	case len(opts.reachableFrom) > 0:
+		return "-reachable-from"
+	case opts.sortMode == sortTopo:
+		return "-sort " + sortTopo
+	case opts.expandIncludes:
+		return "-expand-includes"
+	}
+	return ""
+}
+
+// countingWriter counts the bytes written through it, giving the offset of
+// each section in the output
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// renderSection returns an entry's section exactly as writeFileEntry writes
+// it to the output, with the SHA-256 of its bytes
+func renderSection(entry *FileEntry, opts *Options) ([]byte, string, error) {
+	var buf bytes.Buffer
+	if err := writeFileEntry(&buf, entry, opts); err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(sum[:]), nil
+}
+
+// writeSectionIndex stamps the index with the output's current size and
+// modification time and writes it to path
+func writeSectionIndex(path, outputPath string, index *sectionIndex) error {
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return err
+	}
+	index.Size = info.Size()
+	index.ModTime = info.ModTime().UnixNano()
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// loadSectionIndex reads the index at path and checks that it still
+// describes the output
+func loadSectionIndex(path, outputPath string) (*sectionIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var index sectionIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != index.Size || info.ModTime().UnixNano() != index.ModTime {
+		return nil, fmt.Errorf("%s changed since its section index was written", outputPath)
+	}
+	return &index, nil
+}
+
+// find returns the position of the section for path, or -1
+func (index *sectionIndex) find(path string) int {
+	path = filepath.Clean(path)
+	return slices.IndexFunc(index.Sections, func(s sectionRange) bool {
+		return filepath.Clean(s.Path) == path
+	})
+}
+
+// sectionPatch replaces the section at position i of an index
+type sectionPatch struct {
+	i       int
+	content []byte
+	hash    string
+}
+
+// patchSections rewrites the given sections of the output and shifts the
+// ranges after them. The new output is written next to the old one and
+// renamed over it, so readers never see a half-patched file.
+func patchSections(outputPath string, index *sectionIndex, patches []sectionPatch) error {
+	slices.SortFunc(patches, func(a, b sectionPatch) int { return a.i - b.i })
+
+	old, err := os.Open(outputPath)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	info, err := old.Stat()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(outputPath), filepath.Base(outputPath)+".patch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = func() error {
+		var pos int64
+		for _, patch := range patches {
+			section := index.Sections[patch.i]
+			if _, err := io.CopyN(tmp, old, section.Start-pos); err != nil {
+				return err
+			}
+			if _, err := tmp.Write(patch.content); err != nil {
+				return err
+			}
+			if _, err := old.Seek(section.End, io.SeekStart); err != nil {
+				return err
+			}
+			pos = section.End
+		}
+		_, err := io.Copy(tmp, old)
+		return err
+	}()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), outputPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Shift every range by what the sections before it grew or shrank
+	var shift int64
+	next := 0
+	for i := range index.Sections {
+		section := &index.Sections[i]
+		size := section.End - section.Start
+		section.Start += shift
+		if next < len(patches) && patches[next].i == i {
+			newSize := int64(len(patches[next].content))
+			section.Hash = patches[next].hash
+			shift += newSize - size
+			size = newSize
+			next++
+		}
+		section.End = section.Start + size
+	}
+	return nil
+}
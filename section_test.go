@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWatchPatchMatchesFullRun checks that patching the sections of changed
+// files in place gives the same output as regenerating it, and that changes
+// a patch cannot express are left to a full run
+func TestWatchPatchMatchesFullRun(t *testing.T) {
+	dir := writeTestTree(t)
+	outDir := t.TempDir()
+	output := filepath.Join(outDir, "out.txt")
+	indexPath := filepath.Join(outDir, "sections.json")
+	runSinglegen(t, "-dir", dir, "-output", output, "-deterministic", "-section-index", indexPath)
+
+	w := &watcher{
+		opts:      &Options{dirPath: dir, outputPath: output, format: formatText, compress: compressNone, deterministic: true},
+		indexPath: indexPath,
+	}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// A file that grows, one that shrinks and one rewritten unchanged
+	changed := []string{
+		write("dup/a.txt", "different and rather longer content\n"),
+		write("gen/d1/file01.txt", "short\n"),
+		write("src/main.go", "package main\n\nfunc main() {}\n"),
+	}
+	// An editor's temporary file that came and went
+	changed = append(changed, filepath.Join(dir, "src", ".main.go.swp"))
+	if !w.patch(changed) {
+		t.Fatal("patch declined edits to files already in the output")
+	}
+
+	// A second patch relies on the index the first one updated
+	changed = []string{write("docs/README.md", "# Docs\n\nRewritten.\n")}
+	if !w.patch(changed) {
+		t.Fatal("patch declined an edit after a previous patch")
+	}
+
+	patched, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fresh := filepath.Join(outDir, "fresh.txt")
+	runSinglegen(t, "-dir", dir, "-output", fresh, "-deterministic")
+	want, err := os.ReadFile(fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(patched, want) {
+		t.Errorf("patched output differs from a full run\npatched:\n%s\nfull run:\n%s", patched, want)
+	}
+
+	// New files add a section, so they need a full run
+	if w.patch([]string{write("src/new.go", "package main\n")}) {
+		t.Error("patch accepted a file that is not in the output")
+	}
+
+	// As does any change to the output behind the index's back
+	if err := os.WriteFile(output, append(patched, '\n'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if w.patch([]string{filepath.Join(dir, "src", "main.go")}) {
+		t.Error("patch accepted an output that changed since the index was written")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "path/filepath"
+
+// applyLanguageCap keeps at most n entries of each detected language, in their
+// current order. It returns the kept entries and how many were dropped per
+// language; files with no recognized language share the "other" bucket.
+func applyLanguageCap(entries []*FileEntry, n int) ([]*FileEntry, map[string]int) {
+	kept := entries[:0:0]
+	counts := make(map[string]int)
+	dropped := make(map[string]int)
+
+	for _, entry := range entries {
+		lang := detectLanguage(entry.relPath)
+		if lang == "" {
+			lang = "other"
+		}
+
+		if counts[lang] >= n {
+			dropped[lang]++
+			if entry.release != nil {
+				entry.release()
+			}
+			continue
+		}
+		counts[lang]++
+		kept = append(kept, entry)
+	}
+
+	return kept, dropped
+}
+
+// applyDirByteCap keeps entries, in their current order, until the files
+// from one directory would exceed n bytes in total; the rest of that
+// directory is dropped. It returns the kept entries and how many were
+// dropped per directory.
+func applyDirByteCap(entries []*FileEntry, n int64) ([]*FileEntry, map[string]int) {
+	kept := entries[:0:0]
+	totals := make(map[string]int64)
+	full := make(map[string]bool)
+	dropped := make(map[string]int)
+
+	for _, entry := range entries {
+		dir := filepath.ToSlash(filepath.Dir(entry.relPath))
+		size := int64(len(entry.content))
+
+		if full[dir] || totals[dir]+size > n {
+			full[dir] = true
+			dropped[dir]++
+			if entry.release != nil {
+				entry.release()
+			}
+			continue
+		}
+		totals[dir] += size
+		kept = append(kept, entry)
+	}
+
+	return kept, dropped
+}
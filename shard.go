@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// shardResult is the outcome of writing one entry to its shard
+type shardResult struct {
+	entry *FileEntry
+	err   error
+}
+
+// shardWriter spreads entries over several output files, each written by its
+// own goroutine. An entry's shard is chosen by hashing its relative path so
+// the assignment is stable across runs.
+type shardWriter struct {
+	opts    *Options
+	paths   []string
+	files   []*os.File
+	queues  []chan *FileEntry
+	results [][]shardResult
+	wg      sync.WaitGroup
+}
+
+// shardFor returns the shard index for a relative path
+func shardFor(relPath string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(filepath.ToSlash(relPath)))
+	return int(h.Sum32() % uint32(n))
+}
+
+// shardPath derives the file name of shard i from the output path
+func shardPath(outputPath string, i int) string {
+	ext := filepath.Ext(outputPath)
+	return fmt.Sprintf("%s.shard-%02d%s", strings.TrimSuffix(outputPath, ext), i, ext)
+}
+
+func newShardWriter(opts *Options) (*shardWriter, error) {
+	w := &shardWriter{
+		opts:    opts,
+		results: make([][]shardResult, opts.shards),
+	}
+	if err := removeStaleSiblings(opts.outputPath, "shard"); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < opts.shards; i++ {
+		path := shardPath(opts.outputPath, i)
+		file, err := os.Create(path)
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+
+		queue := make(chan *FileEntry, 64)
+		w.paths = append(w.paths, path)
+		w.files = append(w.files, file)
+		w.queues = append(w.queues, queue)
+
+		w.wg.Add(1)
+		go func(i int) {
+			defer w.wg.Done()
+			for entry := range queue {
+				err := writeFileEntry(file, entry, opts)
+				w.results[i] = append(w.results[i], shardResult{entry: entry, err: err})
+			}
+		}(i)
+	}
+
+	return w, nil
+}
+
+func (w *shardWriter) send(entry *FileEntry) {
+	w.queues[shardFor(entry.relPath, len(w.queues))] <- entry
+}
+
+// close waits for every shard to finish and returns the write results in
+// shard order
+func (w *shardWriter) close() []shardResult {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+	w.wg.Wait()
+
+	for _, file := range w.files {
+		file.Close()
+	}
+
+	var all []shardResult
+	for _, results := range w.results {
+		all = append(all, results...)
+	}
+	return all
+}
+
+// writeAssignments records which shard each file landed in, sorted by path
+func (w *shardWriter) writeAssignments(results []shardResult) error {
+	var paths []string
+	for _, result := range results {
+		if result.err == nil {
+			paths = append(paths, filepath.ToSlash(result.entry.relPath))
+		}
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s\t%s\n", filepath.Base(w.paths[shardFor(path, len(w.paths))]), path)
+	}
+
+	ext := filepath.Ext(w.opts.outputPath)
+	mapPath := strings.TrimSuffix(w.opts.outputPath, ext) + ".shards.txt"
+	return os.WriteFile(mapPath, []byte(b.String()), 0644)
+}
+
+// concat appends the shards to out in shard order and removes them
+func (w *shardWriter) concat(out io.Writer) error {
+	for _, path := range w.paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Orderings accepted by -sort
+const (
+	sortPath = "path"
+	sortNone = "none"
+	sortTopo = "topo"
+)
+
+// sortEntries orders buffered entries by relative path using forward slashes,
+// applying any ordering heuristics enabled on the command line
+func sortEntries(entries []*FileEntry, opts *Options) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if opts.orderByInclude && entries[i].includeIndex != entries[j].includeIndex {
+			return entries[i].includeIndex < entries[j].includeIndex
+		}
+
+		a := filepath.ToSlash(entries[i].relPath)
+		b := filepath.ToSlash(entries[j].relPath)
+
+		// Paths differing only in case fall back to their original spelling
+		// so the order stays the same whichever worker finished first
+		if opts.lowercasePaths {
+			if la, lb := strings.ToLower(a), strings.ToLower(b); la != lb {
+				a, b = la, lb
+			}
+		}
+
+		if opts.readmeFirst {
+			if less, decided := indexFirst(a, b, opts.indexNames); decided {
+				return less
+			}
+		}
+		return a < b
+	})
+}
+
+// sortTopological reorders path-sorted entries so local imports come before
+// the files importing them. Cycles are broken by visiting files and their
+// dependencies in path order and skipping any file already being visited.
+// Files with no local dependencies that nothing depends on either follow in
+// path order.
+func sortTopological(entries []*FileEntry, dirPath string) []*FileEntry {
+	g := newImportGraph(entries, dirPath)
+
+	deps := make(map[string][]string)
+	linked := make(map[string]bool)
+	for _, entry := range entries {
+		rel := filepath.ToSlash(entry.relPath)
+		for _, dep := range g.deps(rel) {
+			if dep != rel && !slices.Contains(deps[rel], dep) {
+				deps[rel] = append(deps[rel], dep)
+				linked[rel], linked[dep] = true, true
+			}
+		}
+		slices.Sort(deps[rel])
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var ordered []*FileEntry
+	var visit func(rel string)
+	visit = func(rel string) {
+		if state[rel] != 0 {
+			return
+		}
+		state[rel] = visiting
+		for _, dep := range deps[rel] {
+			visit(dep)
+		}
+		state[rel] = done
+		ordered = append(ordered, g.files[rel])
+	}
+
+	for _, entry := range entries {
+		if rel := filepath.ToSlash(entry.relPath); linked[rel] {
+			visit(rel)
+		}
+	}
+	for _, entry := range entries {
+		if !linked[filepath.ToSlash(entry.relPath)] {
+			ordered = append(ordered, entry)
+		}
+	}
+	return ordered
+}
+
+// dedupFoldedPaths drops entries whose path differs from an earlier entry's
+// only by case and whose content is identical, as happens when the same file
+// is reached under two spellings. Entries must already be sorted.
+func dedupFoldedPaths(entries []*FileEntry) (kept, duplicates []*FileEntry) {
+	seen := make(map[string][]string)
+	for _, entry := range entries {
+		key := strings.ToLower(filepath.ToSlash(entry.relPath))
+		if slices.Contains(seen[key], entry.hash) {
+			duplicates = append(duplicates, entry)
+			continue
+		}
+		seen[key] = append(seen[key], entry.hash)
+		kept = append(kept, entry)
+	}
+	return kept, duplicates
+}
+
+// indexRank returns the position of name in the configured index file names,
+// or -1 if it is not an index file
+func indexRank(name string, indexNames []string) int {
+	for i, index := range indexNames {
+		if strings.EqualFold(name, index) {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexFirst decides the order of two slash-separated paths when one of them
+// is an index file directly inside the directory where the paths diverge. It
+// reports false for decided when the plain path order should be used.
+func indexFirst(a, b string, indexNames []string) (less, decided bool) {
+	aParts := strings.Split(a, "/")
+	bParts := strings.Split(b, "/")
+
+	k := 0
+	for k < len(aParts) && k < len(bParts) && aParts[k] == bParts[k] {
+		k++
+	}
+	if k == len(aParts) || k == len(bParts) {
+		return false, false
+	}
+
+	aRank, bRank := -1, -1
+	if k == len(aParts)-1 {
+		aRank = indexRank(aParts[k], indexNames)
+	}
+	if k == len(bParts)-1 {
+		bRank = indexRank(bParts[k], indexNames)
+	}
+
+	switch {
+	case aRank >= 0 && bRank >= 0 && aRank != bRank:
+		return aRank < bRank, true
+	case aRank >= 0 && bRank < 0:
+		return true, true
+	case bRank >= 0 && aRank < 0:
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// submodule is a git submodule declared in the superproject's .gitmodules
+type submodule struct {
+	name       string
+	path       string
+	ignoreList *IgnoreList
+}
+
+// loadSubmodules parses dir/.gitmodules and loads each initialized
+// submodule's own ignore rules. Uninitialized submodules are returned
+// separately so the caller can warn about them.
+func loadSubmodules(dir string) (loaded []*submodule, uninitialized []string, err error) {
+	file, err := os.Open(filepath.Join(dir, ".gitmodules"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var declared []*submodule
+	var current *submodule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "[submodule"):
+			name := strings.TrimSuffix(strings.TrimPrefix(line, "[submodule"), "]")
+			current = &submodule{name: strings.Trim(strings.TrimSpace(name), `"`)}
+			declared = append(declared, current)
+		case strings.HasPrefix(line, "["):
+			current = nil
+		case current != nil:
+			key, value, found := strings.Cut(line, "=")
+			if found && strings.TrimSpace(key) == "path" {
+				current.path = filepath.ToSlash(filepath.Clean(strings.TrimSpace(value)))
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, sub := range declared {
+		if sub.path == "" {
+			continue
+		}
+
+		root := filepath.Join(dir, filepath.FromSlash(sub.path))
+		if _, err := os.Stat(filepath.Join(root, ".git")); err != nil {
+			uninitialized = append(uninitialized, sub.path)
+			continue
+		}
+
+		sub.ignoreList, err = NewIgnoreList(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		loaded = append(loaded, sub)
+	}
+
+	return loaded, uninitialized, nil
+}
+
+// findSubmodule returns the submodule containing relPath and the path relative
+// to the submodule root, or nil if relPath belongs to the superproject
+func findSubmodule(submodules []*submodule, relPath string) (*submodule, string) {
+	slashed := filepath.ToSlash(relPath)
+
+	var best *submodule
+	for _, sub := range submodules {
+		if strings.HasPrefix(slashed, sub.path+"/") && (best == nil || len(sub.path) > len(best.path)) {
+			best = sub
+		}
+	}
+	if best == nil {
+		return nil, relPath
+	}
+
+	return best, filepath.FromSlash(strings.TrimPrefix(slashed, best.path+"/"))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Error categories reported in the summary breakdown
+const (
+	errTimedOut   = "timed out"
+	errPermission = "permission denied"
+	errMissing    = "missing"
+	errOther      = "other"
+)
+
+var errorCategories = []string{errTimedOut, errPermission, errMissing, errOther}
+
+// Summary accumulates statistics about the files written during a run
+type Summary struct {
+	files    int
+	bytes    int64
+	tokens   int
+	errors   int
+	treeHash string
+
+	invalidUTF8  int
+	secrets      int
+	syntaxErrors int
+
+	// byDir is only allocated when the per-directory breakdown is requested
+	byDir map[string]*dirStats
+
+	// sizeHistogram is only allocated when -size-histogram is set and has
+	// one slot per entry in sizeBucketLabels
+	sizeHistogram []dirStats
+
+	// countTokens is the -tokenizer count
+	countTokens func([]byte) int
+
+	// tokenBudget is set when -max-tokens selected the files
+	tokenBudget *budgetReport
+
+	// languageCapped counts files dropped by -per-language-cap
+	languageCapped map[string]int
+
+	// dirCapped counts files dropped by -max-dir-bytes per directory
+	dirCapped map[string]int
+
+	failed  map[string][]string
+	skipped map[string][]string
+
+	// unreadableDirs lists directories skipped by -skip-unreadable
+	unreadableDirs []string
+}
+
+// dirStats totals the files under one top-level directory
+type dirStats struct {
+	files int
+	bytes int64
+}
+
+// sizeBucketLimits are the exclusive upper bounds of the logarithmic size
+// buckets; larger files fall into a final open-ended bucket
+var sizeBucketLimits = []int64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20, 100 << 20}
+
+var sizeBucketLabels = []string{"0-1KB", "1-10KB", "10-100KB", "100KB-1MB", "1-10MB", "10-100MB", "100MB+"}
+
+// sizeBucket returns the histogram bucket index for a file of size bytes
+func sizeBucket(size int64) int {
+	for i, limit := range sizeBucketLimits {
+		if size < limit {
+			return i
+		}
+	}
+	return len(sizeBucketLimits)
+}
+
+// topLevelDir returns the first segment of a relative path, or "." for files
+// at the root of the scanned directory
+func topLevelDir(relPath string) string {
+	relPath = filepath.ToSlash(relPath)
+	if dir, _, found := strings.Cut(relPath, "/"); found {
+		return dir + "/"
+	}
+	return "."
+}
+
+// errorCategory classifies a file error so flaky storage can be told apart
+// from real read problems
+func errorCategory(err error) string {
+	var timeout interface{ Timeout() bool }
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()):
+		return errTimedOut
+	case errors.Is(err, fs.ErrPermission):
+		return errPermission
+	case errors.Is(err, fs.ErrNotExist):
+		return errMissing
+	default:
+		return errOther
+	}
+}
+
+func (s *Summary) addSkipped(reason, path string) {
+	if s.skipped == nil {
+		s.skipped = make(map[string][]string)
+	}
+	s.skipped[reason] = append(s.skipped[reason], path)
+}
+
+// jsonStats returns the totals written by -json-with-stats
+func (s *Summary) jsonStats() jsonStats {
+	stats := jsonStats{
+		Files:    s.files,
+		Bytes:    s.bytes,
+		Tokens:   s.tokens,
+		Errors:   s.errors,
+		TreeHash: s.treeHash,
+	}
+	for _, paths := range s.skipped {
+		stats.Skipped += len(paths)
+	}
+	return stats
+}
+
+func (s *Summary) addError(path string, err error) {
+	if s.failed == nil {
+		s.failed = make(map[string][]string)
+	}
+
+	s.errors++
+	category := errorCategory(err)
+	s.failed[category] = append(s.failed[category], path)
+}
+
+func (s *Summary) add(entry *FileEntry) {
+	s.files++
+	s.bytes += int64(len(entry.content))
+	s.tokens += s.countTokens(entry.content)
+
+	if s.byDir != nil {
+		dir := topLevelDir(entry.relPath)
+		stats, ok := s.byDir[dir]
+		if !ok {
+			stats = &dirStats{}
+			s.byDir[dir] = stats
+		}
+		stats.files++
+		stats.bytes += int64(len(entry.content))
+	}
+
+	if s.sizeHistogram != nil {
+		bucket := &s.sizeHistogram[sizeBucket(int64(len(entry.content)))]
+		bucket.files++
+		bucket.bytes += int64(len(entry.content))
+	}
+}
+
+func (s *Summary) print(w io.Writer) {
+	fmt.Fprintf(w, "Files: %d, Bytes: %d, Errors: %d\n", s.files, s.bytes, s.errors)
+
+	if s.errors > 0 {
+		counts := make([]string, len(errorCategories))
+		for i, category := range errorCategories {
+			counts[i] = fmt.Sprintf("%s: %d", category, len(s.failed[category]))
+		}
+		fmt.Fprintf(w, "  %s\n", strings.Join(counts, ", "))
+
+		if timedOut := s.failed[errTimedOut]; len(timedOut) > 0 {
+			sort.Strings(timedOut)
+			fmt.Fprintln(w, "Timed out files:")
+			for _, path := range timedOut {
+				fmt.Fprintf(w, "  %s\n", path)
+			}
+		}
+	}
+
+	if s.syntaxErrors > 0 {
+		fmt.Fprintf(w, "Files with syntax errors: %d\n", s.syntaxErrors)
+	}
+
+	if len(s.skipped) > 0 {
+		reasons := slices.Sorted(maps.Keys(s.skipped))
+
+		counts := make([]string, len(reasons))
+		for i, reason := range reasons {
+			counts[i] = fmt.Sprintf("%s: %d", reason, len(s.skipped[reason]))
+		}
+		fmt.Fprintf(w, "Skipped: %s\n", strings.Join(counts, ", "))
+	}
+
+	if inFlux := s.skipped[skipInFlux]; len(inFlux) > 0 {
+		sort.Strings(inFlux)
+		fmt.Fprintln(w, "Recently modified files skipped (rerun to include them):")
+		for _, path := range inFlux {
+			fmt.Fprintf(w, "  %s\n", path)
+		}
+	}
+
+	if len(s.unreadableDirs) > 0 {
+		sort.Strings(s.unreadableDirs)
+		fmt.Fprintln(w, "Unreadable directories skipped:")
+		for _, dir := range s.unreadableDirs {
+			fmt.Fprintf(w, "  %s\n", dir)
+		}
+	}
+
+	if len(s.languageCapped) > 0 {
+		langs := slices.Sorted(maps.Keys(s.languageCapped))
+
+		counts := make([]string, len(langs))
+		for i, lang := range langs {
+			counts[i] = fmt.Sprintf("%s: %d", lang, s.languageCapped[lang])
+		}
+		fmt.Fprintf(w, "Dropped by per-language cap: %s\n", strings.Join(counts, ", "))
+	}
+
+	if len(s.dirCapped) > 0 {
+		dirs := slices.Sorted(maps.Keys(s.dirCapped))
+
+		fmt.Fprintln(w, "Truncated by per-directory byte cap:")
+		for _, dir := range dirs {
+			fmt.Fprintf(w, "  %-30s %6d files dropped\n", dir, s.dirCapped[dir])
+		}
+	}
+
+	if s.byDir != nil {
+		dirs := slices.Sorted(maps.Keys(s.byDir))
+
+		fmt.Fprintln(w, "By top-level directory:")
+		for _, dir := range dirs {
+			stats := s.byDir[dir]
+			fmt.Fprintf(w, "  %-30s %6d files %12d bytes\n", dir, stats.files, stats.bytes)
+		}
+	}
+
+	if s.sizeHistogram != nil {
+		fmt.Fprintln(w, "File size histogram:")
+		for i, bucket := range s.sizeHistogram {
+			fmt.Fprintf(w, "  %-30s %6d files %12d bytes\n", sizeBucketLabels[i], bucket.files, bucket.bytes)
+		}
+	}
+
+	if b := s.tokenBudget; b != nil {
+		fmt.Fprintf(w, "Token budget: %d of %d estimated tokens used (%.1f%%), %d of %d files included\n",
+			b.used, b.budget, 100*float64(b.used)/float64(b.budget), b.chosen, b.candidates)
+		if b.truncated > 0 {
+			fmt.Fprintf(w, "Truncated %d files to fit the token budget\n", b.truncated)
+		}
+	}
+
+	if s.secrets > 0 {
+		fmt.Fprintf(w, "Secrets detected: %d\n", s.secrets)
+	}
+
+	if s.invalidUTF8 > 0 {
+		fmt.Fprintf(w, "Invalid UTF-8: %d files\n", s.invalidUTF8)
+	}
+
+	if s.treeHash != "" {
+		fmt.Fprintf(w, "Tree hash: %s\n", s.treeHash)
+	}
+}
+
+// printProblems lists every file that failed, sorted by path, for -validate
+func (s *Summary) printProblems(w io.Writer) {
+	if s.errors == 0 {
+		fmt.Fprintf(w, "Validation passed: %d files read and transformed\n", s.files)
+		return
+	}
+
+	var paths []string
+	for _, failed := range s.failed {
+		paths = append(paths, failed...)
+	}
+	sort.Strings(paths)
+	fmt.Fprintln(w, "Problematic files:")
+	for _, path := range paths {
+		fmt.Fprintf(w, "  %s\n", path)
+	}
+}
+
+// checkExpectations compares the totals against the expected ranges from the
+// command line and returns the first violation
+func (s *Summary) checkExpectations(opts *Options) error {
+	if opts.validate && s.errors > 0 {
+		return fmt.Errorf("validation failed: %d files could not be read or transformed", s.errors)
+	}
+	if opts.failOnSecrets && s.secrets > 0 {
+		return fmt.Errorf("%d possible secrets detected", s.secrets)
+	}
+	if opts.strict && s.invalidUTF8 > 0 {
+		return fmt.Errorf("%d files contain invalid UTF-8", s.invalidUTF8)
+	}
+	if err := opts.expectFiles.check("file count", int64(s.files)); err != nil {
+		return err
+	}
+	return opts.expectBytes.check("byte count", s.bytes)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// syntaxCheckers parse content in the languages -validate-syntax can check.
+// Other languages are left unchecked.
+var syntaxCheckers = map[string]func(name string, content []byte) error{
+	"go":   checkGoSyntax,
+	"json": checkJSONSyntax,
+	"xml":  checkXMLSyntax,
+	"yaml": checkYAMLSyntax,
+}
+
+// checkSyntax returns a short description of the first parse error in the
+// file at relPath, or an empty string if it parses or cannot be checked
+func checkSyntax(relPath string, content []byte) string {
+	check, ok := syntaxCheckers[detectLanguage(relPath)]
+	if !ok {
+		return ""
+	}
+	if err := check(relPath, content); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func checkGoSyntax(name string, content []byte) error {
+	_, err := parser.ParseFile(token.NewFileSet(), name, content, parser.SkipObjectResolution)
+	var list scanner.ErrorList
+	if errors.As(err, &list) && len(list) > 0 {
+		first := list[0]
+		msg := fmt.Sprintf("line %d:%d: %s", first.Pos.Line, first.Pos.Column, first.Msg)
+		if len(list) > 1 {
+			msg += fmt.Sprintf(" (and %d more)", len(list)-1)
+		}
+		return errors.New(msg)
+	}
+	return err
+}
+
+func checkJSONSyntax(name string, content []byte) error {
+	if json.Valid(content) {
+		return nil
+	}
+
+	// Decode again only to find where it fails
+	var v any
+	err := json.Unmarshal(content, &v)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := bytes.Count(content[:syntaxErr.Offset], []byte("\n")) + 1
+		return fmt.Errorf("line %d: %v", line, syntaxErr)
+	}
+	if err == nil {
+		return errors.New("invalid JSON")
+	}
+	return err
+}
+
+func checkXMLSyntax(name string, content []byte) error {
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	// Documents often rely on a DTD for HTML entities such as &nbsp;, which
+	// the decoder does not read, so those are accepted as known
+	decoder.Entity = xml.HTMLEntity
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkYAMLSyntax parses every document in a YAML stream
+func checkYAMLSyntax(name string, content []byte) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.New(strings.TrimPrefix(err.Error(), "yaml: "))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	gitignore "github.com/sabhiram/go-gitignore"
+)
+
+// gitignoreTemplates holds the bundled standard ignore templates, one
+// templates/<name>.gitignore file per preset
+//
+//go:embed templates/*.gitignore
+var gitignoreTemplates embed.FS
+
+// gitignoreTemplateNames lists the bundled template names in sorted order
+func gitignoreTemplateNames() []string {
+	files, _ := fs.Glob(gitignoreTemplates, "templates/*.gitignore")
+
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = strings.TrimSuffix(path.Base(file), ".gitignore")
+	}
+	return names
+}
+
+// gitignoreTemplateFile returns the embedded file holding the named template
+func gitignoreTemplateFile(name string) string {
+	return "templates/" + strings.ToLower(name) + ".gitignore"
+}
+
+// compileGitignoreTemplates combines the named templates into one matcher.
+// Later templates can re-include paths ignored by earlier ones, just as if
+// their lines had been concatenated into a single .gitignore.
+func compileGitignoreTemplates(names []string) (*gitignore.GitIgnore, error) {
+	var lines []string
+	for _, name := range names {
+		data, err := gitignoreTemplates.ReadFile(gitignoreTemplateFile(name))
+		if err != nil {
+			return nil, fmt.Errorf("unknown gitignore template %q (available: %s)",
+				name, strings.Join(gitignoreTemplateNames(), ", "))
+		}
+		lines = append(lines, strings.Split(string(data), "\n")...)
+	}
+	return gitignore.CompileIgnoreLines(lines...), nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// processWithTimeout runs process for the file at path and gives up once
+// -file-timeout passes, with an error wrapping os.ErrDeadlineExceeded so the
+// summary counts it as timed out. Regular files take no read deadline, so a
+// hung read is abandoned rather than interrupted; should it finish later,
+// whatever its entry holds is released.
+func processWithTimeout(path string, opts *Options, process func() (*FileEntry, error)) (*FileEntry, error) {
+	if opts.fileTimeout <= 0 {
+		return process()
+	}
+
+	type result struct {
+		entry *FileEntry
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		entry, err := process()
+		done <- result{entry, err}
+	}()
+
+	timer := time.NewTimer(opts.fileTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.entry, r.err
+	case <-timer.C:
+		go func() {
+			if r := <-done; r.entry != nil && r.entry.release != nil {
+				r.entry.release()
+			}
+		}()
+		return nil, fmt.Errorf("reading %s: %w after %s", path, os.ErrDeadlineExceeded, opts.fileTimeout)
+	}
+}
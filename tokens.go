@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// bytesPerToken is the rough average used to estimate token counts for
+// typical source code and prose
+const bytesPerToken = 4
+
+// estimateTokens approximates the number of LLM tokens in b
+func estimateTokens(b []byte) int {
+	return (len(b) + bytesPerToken - 1) / bytesPerToken
+}
+
+// tokenizer counts tokens for -tokenizer. None of them load a vocabulary;
+// the BPE ones only follow how their encoding splits text, which is close
+// enough to plan a budget.
+type tokenizer struct {
+	name  string
+	count func([]byte) int
+}
+
+var tokenizers = map[string]*tokenizer{
+	"bytes":  {name: "bytes", count: estimateTokens},
+	"cl100k": {name: "cl100k", count: bpeProfile{wordBytes: 6, nonASCII: 1}.count},
+	"o200k":  {name: "o200k", count: bpeProfile{wordBytes: 7, nonASCII: 0.6}.count},
+}
+
+// tokenizerNames returns the -tokenizer names, sorted
+func tokenizerNames() string {
+	var names []string
+	for name := range tokenizers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return strings.Join(names, ", ")
+}
+
+// bpeProfile approximates a BPE encoding such as cl100k_base. Text is split
+// the way its pre-tokenizer splits it: words taking one leading space,
+// digits, punctuation and whitespace. Each piece then costs what the
+// encoding typically spends on a piece of that kind and length.
+type bpeProfile struct {
+	wordBytes int     // ASCII letters covered by one token
+	nonASCII  float64 // tokens per non-ASCII character
+}
+
+// Kinds of pieces bpeProfile.count splits text into
+const (
+	pieceWord = iota
+	pieceDigits
+	pieceSpace
+	pieceNonASCII
+	piecePunct
+)
+
+func pieceKind(r rune) int {
+	switch {
+	case r < utf8.RuneSelf && ('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'):
+		return pieceWord
+	case '0' <= r && r <= '9':
+		return pieceDigits
+	case unicode.IsSpace(r):
+		return pieceSpace
+	case r >= utf8.RuneSelf:
+		return pieceNonASCII
+	}
+	return piecePunct
+}
+
+func (p bpeProfile) count(b []byte) int {
+	tokens := 0
+	for i := 0; i < len(b); {
+		// A single space belongs to the piece after it
+		if b[i] == ' ' && i+1 < len(b) && pieceKind(rune(b[i+1])) != pieceSpace {
+			i++
+		}
+
+		r, size := utf8.DecodeRune(b[i:])
+		kind := pieceKind(r)
+		end, chars := i+size, 1
+		for end < len(b) {
+			r, size := utf8.DecodeRune(b[end:])
+			if pieceKind(r) != kind {
+				break
+			}
+			end += size
+			chars++
+		}
+
+		switch kind {
+		case pieceWord:
+			tokens += ceilDiv(chars, p.wordBytes)
+		case pieceDigits:
+			tokens += ceilDiv(chars, 3)
+		case pieceSpace:
+			tokens += ceilDiv(chars, 8)
+		case pieceNonASCII:
+			tokens += int(math.Ceil(float64(chars) * p.nonASCII))
+		case piecePunct:
+			tokens += ceilDiv(chars, 2)
+		}
+		i = end
+	}
+	return tokens
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
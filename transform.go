@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Transform rewrites a file's content after it is read and before it is
+// hashed and written. Transforms run in the order they were registered.
+type Transform func(entry *FileEntry, content []byte) ([]byte, error)
+
+// applyTransforms runs every registered transform over the entry's content
+func applyTransforms(entry *FileEntry, opts *Options) error {
+	for _, transform := range opts.transforms {
+		content, err := transform(entry, entry.content)
+		if err != nil {
+			return err
+		}
+		entry.content = content
+	}
+	return nil
+}
+
+// replaceRule is a single literal or regex substitution
+type replaceRule struct {
+	old         []byte
+	new         []byte
+	re          *regexp.Regexp
+	replacement []byte
+}
+
+// replaceFlag parses -replace or -replace-re values into a rule list shared by
+// both flags, so substitutions apply in the order given on the command line
+type replaceFlag struct {
+	rules *[]replaceRule
+	regex bool
+}
+
+func (f *replaceFlag) String() string {
+	return ""
+}
+
+func (f *replaceFlag) Set(value string) error {
+	var rule replaceRule
+	var err error
+	if f.regex {
+		rule, err = parseRegexReplace(value)
+	} else {
+		rule, err = parseLiteralReplace(value)
+	}
+	if err != nil {
+		return err
+	}
+
+	*f.rules = append(*f.rules, rule)
+	return nil
+}
+
+// parseLiteralReplace parses 'old=new', where '\=' stands for a literal '='
+// and '\\' for a literal backslash
+func parseLiteralReplace(value string) (replaceRule, error) {
+	var parts [2]strings.Builder
+	part := 0
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value) && (value[i+1] == '=' || value[i+1] == '\\'):
+			i++
+			parts[part].WriteByte(value[i])
+		case c == '=' && part == 0:
+			part = 1
+		default:
+			parts[part].WriteByte(c)
+		}
+	}
+
+	if part == 0 {
+		return replaceRule{}, fmt.Errorf("expected old=new, got %q", value)
+	}
+	if parts[0].Len() == 0 {
+		return replaceRule{}, fmt.Errorf("empty search string in %q", value)
+	}
+
+	return replaceRule{old: []byte(parts[0].String()), new: []byte(parts[1].String())}, nil
+}
+
+// parseRegexReplace parses '/regex/replacement/'. Any character may be used as
+// the delimiter; an escaped delimiter stands for the literal character.
+func parseRegexReplace(value string) (replaceRule, error) {
+	if len(value) < 3 {
+		return replaceRule{}, fmt.Errorf("expected /regex/replacement/, got %q", value)
+	}
+
+	delim := value[0]
+	var parts []string
+	var current strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '\\' && i+1 < len(value) && value[i+1] == delim:
+			i++
+			if len(parts) == 0 {
+				current.WriteString(regexp.QuoteMeta(string(delim)))
+			} else {
+				current.WriteByte(delim)
+			}
+		case c == delim:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+
+	if len(parts) != 2 || current.Len() != 0 {
+		return replaceRule{}, fmt.Errorf("expected %cregex%creplacement%c, got %q", delim, delim, delim, value)
+	}
+
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return replaceRule{}, fmt.Errorf("invalid regular expression %q: %v", parts[0], err)
+	}
+
+	return replaceRule{re: re, replacement: []byte(parts[1])}, nil
+}
+
+// replaceTransform applies the rules in order to each file's content
+func replaceTransform(rules []replaceRule) Transform {
+	return func(entry *FileEntry, content []byte) ([]byte, error) {
+		for _, rule := range rules {
+			if rule.re != nil {
+				content = rule.re.ReplaceAll(content, rule.replacement)
+			} else {
+				content = bytes.ReplaceAll(content, rule.old, rule.new)
+			}
+		}
+		return content, nil
+	}
+}
+
+// stripLinePrefixTransform removes a prefix from every line that starts with
+// a match of one of the patterns, trying them in order. Lines where no
+// pattern matches at the start are left intact.
+func stripLinePrefixTransform(patterns regexpList) Transform {
+	return func(entry *FileEntry, content []byte) ([]byte, error) {
+		lines := bytes.SplitAfter(content, []byte("\n"))
+		out := make([]byte, 0, len(content))
+		for _, line := range lines {
+			body := bytes.TrimSuffix(line, []byte("\n"))
+			for _, re := range patterns {
+				if loc := re.FindIndex(body); loc != nil && loc[0] == 0 && loc[1] > 0 {
+					line = line[loc[1]:]
+					break
+				}
+			}
+			out = append(out, line...)
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unpackedFile is one file recovered from a combined output
+type unpackedFile struct {
+	path    string // as written in the output
+	content []byte
+	modTime time.Time // zero when the output carries none
+
+	// skip is why the file is not written, e.g. because the output holds
+	// only part of it
+	skip string
+}
+
+// parsedOutput is what -unpack recovers from a combined output
+type parsedOutput struct {
+	source string // the source directory, when the output records it
+	files  []unpackedFile
+}
+
+var (
+	// omitNotePattern matches the whole body of a file left out of the
+	// output, as written by omitNote
+	omitNotePattern = regexp.MustCompile(`^### \[(?:skipped: \d+ bytes exceeds limit|[a-z ]+ file skipped)\]\n$`)
+
+	// partialPattern matches the markers of transforms that keep only part
+	// of a file
+	partialPattern = regexp.MustCompile(`(?m)^(?:### \[(?:preview|truncated|excerpt): |… \[\d+ lines omitted\]$)`)
+
+	// textHeaderPattern finds where a file or merged group header starts in
+	// text output. Headers always follow a blank line.
+	textHeaderPattern = regexp.MustCompile(`\n\n(### (?:File(?: \[\d+\])?|Directory): )`)
+
+	// textBoundaryPattern finds where any block after a file's content
+	// starts in text output: another file or group, a section, the manifest
+	// or the tree hash footer
+	textBoundaryPattern = regexp.MustCompile(`\n\n(?:#{2,3} |# Manifest:\n|` + regexp.QuoteMeta(treeHashFooter) + `)`)
+
+	// mergedHeaderPattern matches the header of a file in a merged group at
+	// the start of its input
+	mergedHeaderPattern = regexp.MustCompile(`^#### File(?: \[\d+\])?: (.*) \((\d+) bytes\)\n`)
+
+	markdownHeadingPattern = regexp.MustCompile(`(?m)^## (.+)\n`)
+	markdownPreviewPattern = regexp.MustCompile(`^\n_\d+ more lines not shown_\n`)
+)
+
+// parseCombined detects the format of a combined output and recovers its
+// files. Gzip-compressed output is decompressed first.
+func parseCombined(data []byte) (*parsedOutput, error) {
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, err
+		}
+	}
+
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")), bytes.HasPrefix(trimmed, []byte("{")):
+		return parseJSONOutput(trimmed)
+	case bytes.HasPrefix(trimmed, []byte("<?xml")):
+		return parseXMLOutput(trimmed)
+	case bytes.HasPrefix(data, []byte("# Combined File Contents\n\n- ")):
+		return parseMarkdownOutput(data)
+	case bytes.HasPrefix(data, []byte("# Combined File Contents\n#")):
+		return parseTextOutput(data)
+	}
+	return nil, errors.New("not a singlegen output in a format -unpack reads (text, markdown, json, ndjson or xml)")
+}
+
+// checkBody decides whether a file's content can be written back
+func checkBody(file *unpackedFile) {
+	switch {
+	case omitNotePattern.Match(file.content):
+		file.skip = strings.TrimSuffix(strings.TrimPrefix(string(file.content), "### ["), "]\n")
+	case partialPattern.Match(file.content):
+		file.skip = "only part of the file is in the output"
+	}
+}
+
+// parseTextOutput reads -format text output. With a separator nonce every
+// file is delimited exactly. Without one, a file's content is taken to be as
+// long as its Size header when the bytes after that fit, which holds for
+// untransformed files; otherwise it runs to the next header, so content
+// containing a line like a header can end it early.
+func parseTextOutput(data []byte) (*parsedOutput, error) {
+	out := &parsedOutput{}
+	var nonce string
+	for _, line := range strings.Split(string(data[:headerEnd(data)]), "\n") {
+		if value, ok := strings.CutPrefix(line, "# Source Directory: "); ok {
+			out.source = value
+		} else if value, ok := strings.CutPrefix(line, "# Separator Nonce: "); ok {
+			nonce = value
+		}
+	}
+
+	if nonce != "" {
+		start, end := []byte(separatorLine(nonce, "START")), []byte(separatorLine(nonce, "END"))
+		rest := data
+		for {
+			i := bytes.Index(rest, start)
+			if i < 0 {
+				break
+			}
+			rest = rest[i+len(start):]
+			j := bytes.Index(rest, end)
+			if j < 0 {
+				return nil, errors.New("file section without an end separator")
+			}
+			// The content is followed by one line break before the separator
+			block := bytes.TrimSuffix(rest[:j], []byte("\n"))
+			rest = rest[j+len(end):]
+
+			fields, body := readTextHeader(block, 0)
+			if _, ok := fields["Directory"]; ok {
+				count, _ := strconv.Atoi(fields["Merged Small Files"])
+				files, _ := parseMergedGroup(block, body, count, len(block))
+				out.files = append(out.files, files...)
+				continue
+			}
+			out.files = append(out.files, textFile(fields, block[body:]))
+		}
+		return out, nil
+	}
+
+	pos := 0
+	for {
+		m := textHeaderPattern.FindIndex(data[pos:])
+		if m == nil {
+			break
+		}
+		fields, body := readTextHeader(data, pos+m[0]+2)
+		if _, ok := fields["Directory"]; ok {
+			count, _ := strconv.Atoi(fields["Merged Small Files"])
+			var files []unpackedFile
+			files, pos = parseMergedGroup(data, body, count, textContentEnd(data, body))
+			out.files = append(out.files, files...)
+			continue
+		}
+
+		end := -1
+		if size, err := strconv.Atoi(strings.TrimSuffix(fields["Size"], " bytes")); err == nil && exactLength(data, body, size) {
+			end = body + size
+		}
+		if end < 0 {
+			end = textContentEnd(data, body)
+		}
+		out.files = append(out.files, textFile(fields, data[body:end]))
+		pos = end
+	}
+	return out, nil
+}
+
+// headerEnd returns where the comment block at the top of text output ends
+func headerEnd(data []byte) int {
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		return i
+	}
+	return len(data)
+}
+
+// readTextHeader reads the '### Key: value' lines starting at pos and
+// returns them with the offset of the content after the blank line. File
+// labels carrying a citation number are stored under "File".
+func readTextHeader(data []byte, pos int) (map[string]string, int) {
+	fields := make(map[string]string)
+	for pos < len(data) {
+		end := bytes.IndexByte(data[pos:], '\n')
+		if end < 0 {
+			end = len(data) - pos
+		}
+		line := string(data[pos : pos+end])
+		pos += end + 1
+		if line == "" {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimPrefix(line, "### "), ": ")
+		if strings.HasPrefix(key, "File [") {
+			key = "File"
+		}
+		fields[key] = value
+	}
+	return fields, min(pos, len(data))
+}
+
+// exactLength reports whether a content of size bytes starting at body is
+// followed by what ends a file in text output
+func exactLength(data []byte, body, size int) bool {
+	end := body + size
+	if end >= len(data) || data[end] != '\n' {
+		return false
+	}
+	return end+1 == len(data) || data[end+1] == '\n'
+}
+
+// textContentEnd returns where the content starting at body ends when its
+// length is unknown
+func textContentEnd(data []byte, body int) int {
+	if m := textBoundaryPattern.FindIndex(data[body:]); m != nil {
+		return body + m[0]
+	}
+	return body + len(bytes.TrimSuffix(data[body:], []byte("\n")))
+}
+
+func textFile(fields map[string]string, content []byte) unpackedFile {
+	file := unpackedFile{path: fields["File"], content: content}
+	if modTime, err := time.ParseInLocation("2006-01-02 15:04:05", fields["Last Modified"], time.Local); err == nil {
+		file.modTime = modTime
+	}
+	checkBody(&file)
+	return file
+}
+
+// parseMergedGroup reads the count files of a merged group starting at
+// body and returns them with the offset where the group ends. A file is read
+// by its recorded size when what follows fits, which holds for untransformed
+// files; otherwise it runs to the next file header, or to end for the last
+// file. Content written without a final line break gets one in that case.
+func parseMergedGroup(data []byte, body, count, end int) ([]unpackedFile, int) {
+	var files []unpackedFile
+	pos := body
+	for i := range count {
+		h := mergedHeaderPattern.FindSubmatchIndex(data[pos:])
+		if h == nil {
+			break
+		}
+		last := i == count-1
+		start := pos + h[1]
+		file := unpackedFile{path: string(data[pos+h[2] : pos+h[3]])}
+		size, err := strconv.Atoi(string(data[pos+h[4] : pos+h[5]]))
+
+		next := -1
+		if stop := start + size; err == nil && stop <= len(data) {
+			// A line break was added after content lacking one
+			if size > 0 && data[stop-1] != '\n' && stop < len(data) && data[stop] == '\n' {
+				stop++
+			}
+			if last && (stop == len(data) || data[stop] == '\n') || !last && mergedHeaderPattern.Match(data[stop:]) {
+				file.content, next = data[start:start+size], stop
+			}
+		}
+		if next < 0 {
+			// Earlier files read by size may have run past end
+			if end < start {
+				end = textContentEnd(data, start)
+			}
+			next = end
+			if j := bytes.Index(data[start:end], []byte("\n#### File")); !last && j >= 0 {
+				next = start + j + 1
+			}
+			file.content = data[start:next]
+		}
+
+		checkBody(&file)
+		files = append(files, file)
+		pos = next
+	}
+	return files, pos
+}
+
+// parseMarkdownOutput reads -format markdown output. Each file's content is
+// the fenced block after its heading; the fence is longer than any backtick
+// run in the content, so the first line holding only the fence closes it.
+// A line break is added to content lacking one when writing, so such files
+// come back with one.
+func parseMarkdownOutput(data []byte) (*parsedOutput, error) {
+	out := &parsedOutput{}
+	if i := bytes.Index(data, []byte("- Source Directory: `")); i >= 0 {
+		rest := data[i+len("- Source Directory: `"):]
+		if j := bytes.Index(rest, []byte("`\n")); j >= 0 {
+			out.source = string(rest[:j])
+		}
+	}
+
+	pos := 0
+	for {
+		m := markdownHeadingPattern.FindSubmatchIndex(data[pos:])
+		if m == nil {
+			break
+		}
+		heading := string(data[pos+m[2] : pos+m[3]])
+		pos += m[1]
+		if heading == "Contents" {
+			// Skip past the tree, which is in a fenced block of its own
+			if i := bytes.Index(data[pos:], []byte("\n```\n")); i >= 0 {
+				pos += i + len("\n```\n")
+			}
+			continue
+		}
+
+		file := unpackedFile{path: heading}
+		for pos < len(data) {
+			end := bytes.IndexByte(data[pos:], '\n')
+			if end < 0 {
+				break
+			}
+			line := string(data[pos : pos+end])
+			if value, ok := strings.CutPrefix(line, "- Last Modified: "); ok {
+				if modTime, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+					file.modTime = modTime
+				}
+			}
+			if line != "" && !strings.HasPrefix(line, "- ") {
+				break
+			}
+			pos += end + 1
+		}
+
+		if !bytes.HasPrefix(data[pos:], []byte("```")) {
+			// An omitted file has its note in place of a code block
+			file.skip = "no content in the output"
+			if line, _, _ := bytes.Cut(data[pos:], []byte("\n")); bytes.HasPrefix(line, []byte("_[")) {
+				file.skip = strings.Trim(string(line), "_[]")
+			}
+			out.files = append(out.files, file)
+			continue
+		}
+		n := 0
+		for pos+n < len(data) && data[pos+n] == '`' {
+			n++
+		}
+		fence := data[pos : pos+n]
+		open := bytes.IndexByte(data[pos:], '\n')
+		if open < 0 {
+			return nil, fmt.Errorf("unterminated code block for %s", heading)
+		}
+		pos += open + 1
+
+		closing := append(append([]byte{'\n'}, fence...), '\n')
+		end := bytes.Index(data[pos-1:], closing)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated code block for %s", heading)
+		}
+		file.content = data[pos : pos-1+end+1]
+		pos += end + len(closing) - 1
+
+		checkBody(&file)
+		if markdownPreviewPattern.Match(data[pos:]) {
+			file.skip = "only part of the file is in the output"
+		}
+		out.files = append(out.files, file)
+	}
+	return out, nil
+}
+
+// parseJSONOutput reads -format json and ndjson output, with or without
+// -json-with-stats. Records without content share it with the earlier
+// record carrying the same hash. Every record names the directory its path
+// starts with; the first one is taken as the source.
+func parseJSONOutput(data []byte) (*parsedOutput, error) {
+	var records []ndjsonRecord
+	switch {
+	case data[0] == '[':
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, err
+		}
+	case bytes.HasPrefix(data, []byte(`{"files":`)):
+		var doc struct {
+			Files []ndjsonRecord `json:"files"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		records = doc.Files
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var record ndjsonRecord
+			err := decoder.Decode(&record)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if record.Type != "stats" {
+				records = append(records, record)
+			}
+		}
+	}
+
+	out := &parsedOutput{}
+	byHash := make(map[string]ndjsonRecord)
+	for _, record := range records {
+		if out.source == "" {
+			out.source = record.Source
+		}
+		file := unpackedFile{path: record.Path}
+		if modTime, err := time.Parse(time.RFC3339, record.ModTime); err == nil {
+			file.modTime = modTime
+		}
+		if record.Content != nil {
+			byHash[record.Hash] = record
+		} else if first, ok := byHash[record.Hash]; ok {
+			record.Content, record.OmittedLines = first.Content, first.OmittedLines
+		}
+
+		switch {
+		case record.Omitted != "":
+			file.skip = record.Omitted + " file skipped"
+		case record.Content == nil:
+			file.skip = "content missing from the output"
+		default:
+			file.content = []byte(*record.Content)
+			checkBody(&file)
+			if record.OmittedLines > 0 {
+				file.skip = "only part of the file is in the output"
+			}
+		}
+		out.files = append(out.files, file)
+	}
+	return out, nil
+}
+
+// parseXMLOutput reads -format xml output
+func parseXMLOutput(data []byte) (*parsedOutput, error) {
+	var doc struct {
+		Source string `xml:"source,attr"`
+		Files  []struct {
+			Path         string  `xml:"path,attr"`
+			ModTime      string  `xml:"mtime,attr"`
+			Omitted      string  `xml:"omitted,attr"`
+			OmittedLines int     `xml:"omitted-lines,attr"`
+			Content      *string `xml:"content"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	out := &parsedOutput{source: doc.Source}
+	for _, f := range doc.Files {
+		file := unpackedFile{path: f.Path}
+		if modTime, err := time.Parse(time.RFC3339, f.ModTime); err == nil {
+			file.modTime = modTime
+		}
+		switch {
+		case f.Omitted != "":
+			file.skip = f.Omitted + " file skipped"
+		case f.Content == nil:
+			file.skip = "content missing from the output"
+		default:
+			file.content = []byte(*f.Content)
+			checkBody(&file)
+			if f.OmittedLines > 0 {
+				file.skip = "only part of the file is in the output"
+			}
+		}
+		out.files = append(out.files, file)
+	}
+	return out, nil
+}
+
+// unpackTarget returns the path under the -dir tree a recovered file is
+// written to. The output's source directory, or the -dir alias, is removed
+// from the front; anything that would still land outside the tree is
+// refused.
+func unpackTarget(name, source string, opts *Options) (string, error) {
+	name = filepath.ToSlash(name)
+	for _, prefix := range []string{opts.dirAlias, filepath.ToSlash(filepath.Clean(source))} {
+		if prefix == "" || prefix == "." {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(name, strings.TrimSuffix(prefix, "/")+"/"); ok {
+			name = rest
+			break
+		}
+	}
+
+	name = path.Clean(name)
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("refusing to write %s outside the target directory", name)
+	}
+	if err := checkNoSymlinks(opts.dirPath, name); err != nil {
+		return "", err
+	}
+	return filepath.Join(opts.dirPath, filepath.FromSlash(name)), nil
+}
+
+// checkNoSymlinks refuses a target whose existing directories or file below
+// root include a symlink, since writing through one could land outside the
+// tree. name is slash-separated and relative to root.
+func checkNoSymlinks(root, name string) error {
+	current := root
+	for _, part := range strings.Split(name, "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write %s through the symlink %s", name, current)
+		}
+	}
+	return nil
+}
+
+// unpack recreates the files of the combined output at opts.unpackPath under
+// opts.dirPath. Existing files are only overwritten with -force, and nothing
+// is written unless every target path is acceptable.
+func unpack(opts *Options) error {
+	data, err := os.ReadFile(opts.unpackPath)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseCombined(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", opts.unpackPath, err)
+	}
+
+	targets := make([]string, len(parsed.files))
+	var problems []string
+	for i, file := range parsed.files {
+		if file.skip != "" {
+			continue
+		}
+		target, err := unpackTarget(file.path, parsed.source, opts)
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		if _, err := os.Lstat(target); err == nil && !opts.force {
+			problems = append(problems, fmt.Sprintf("%s already exists (use -force to overwrite)", target))
+		}
+		targets[i] = target
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+
+	written := 0
+	for i, file := range parsed.files {
+		if file.skip != "" {
+			fmt.Printf("Skipped %s: %s\n", file.path, file.skip)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(targets[i]), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(targets[i], file.content, 0o644); err != nil {
+			return err
+		}
+		if opts.unpackModTimes && !file.modTime.IsZero() {
+			if err := os.Chtimes(targets[i], file.modTime, file.modTime); err != nil {
+				return err
+			}
+		}
+		if opts.verbose {
+			fmt.Printf("Wrote %s (%d bytes)\n", targets[i], len(file.content))
+		}
+		written++
+	}
+	fmt.Printf("Unpacked %d of %d files into: %s\n", written, len(parsed.files), opts.dirPath)
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// dirLimiter bounds the number of directory handles held open at once while
+// enumerating the tree, including listings read ahead of the walk. It is
+// independent of how many files workers read.
+type dirLimiter chan struct{}
+
+func newDirLimiter(n int) dirLimiter {
+	if n < 1 {
+		n = 1
+	}
+	return make(dirLimiter, n)
+}
+
+// readDir lists a directory in lexical order, holding a limiter slot for as
+// long as the directory handle is open
+func (l dirLimiter) readDir(path string) ([]os.DirEntry, error) {
+	l <- struct{}{}
+	defer func() { <-l }()
+
+	return os.ReadDir(path)
+}
+
+// pendingListing is a directory listing read ahead of the walk reaching it
+type pendingListing struct {
+	done    chan struct{}
+	entries []os.DirEntry
+	err     error
+}
+
+// readDirAhead starts listing a directory in the background. The listing
+// waits for a limiter slot like any other, so read-ahead never holds more
+// handles open than the limit.
+func (l dirLimiter) readDirAhead(path string) *pendingListing {
+	p := &pendingListing{done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		p.entries, p.err = l.readDir(path)
+	}()
+	return p
+}
+
+func (p *pendingListing) wait() ([]os.DirEntry, error) {
+	<-p.done
+	return p.entries, p.err
+}
+
+// readDirNames lists the names in a directory in lexical order, reading them
+// batchSize at a time, and holds a limiter slot while the handle is open
+func (l dirLimiter) readDirNames(path string, batchSize int) ([]string, error) {
+	l <- struct{}{}
+	defer func() { <-l }()
+
+	dir, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	var names []string
+	for {
+		batch, err := dir.Readdirnames(batchSize)
+		names = append(names, batch...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// walkTree walks the tree rooted at root in the same order and with the same
+// callback semantics as filepath.Walk, but reads directories through limiter.
+// A positive batch size switches to walkDirBatched for very wide directories.
+func walkTree(root string, limiter dirLimiter, batchSize int, fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkPath(root, info, nil, limiter, batchSize, fn)
+	}
+
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walkPath visits path and, for a directory, everything below it. listing is
+// the directory's listing if it was read ahead, or nil to read it now.
+func walkPath(path string, info os.FileInfo, listing *pendingListing, limiter dirLimiter, batchSize int, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	if batchSize > 0 {
+		return walkDirBatched(path, info, limiter, batchSize, fn)
+	}
+
+	var entries []os.DirEntry
+	var err error
+	if listing != nil {
+		entries, err = listing.wait()
+	} else {
+		entries, err = limiter.readDir(path)
+	}
+	err1 := fn(path, info, err)
+	// Like filepath.Walk, a failed listing is reported once and the directory
+	// is not descended into, regardless of what the callback returned
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	// The next few subdirectories are listed ahead, concurrently, while the
+	// callback works through the entries in order. Directories the callback
+	// then prunes have been listed for nothing, one level deep.
+	ahead := make(map[int]*pendingListing)
+	next := 0
+	for i, entry := range entries {
+		for ; next < len(entries) && len(ahead) < cap(limiter); next++ {
+			if entries[next].IsDir() {
+				ahead[next] = limiter.readDirAhead(filepath.Join(path, entries[next].Name()))
+			}
+		}
+
+		listing := ahead[i]
+		delete(ahead, i)
+		if err := walkEntry(filepath.Join(path, entry.Name()), entry.Info, listing, limiter, batchSize, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkEntry visits one directory entry, reporting an error from stat to the
+// callback instead of walking it
+func walkEntry(name string, stat func() (os.FileInfo, error), listing *pendingListing, limiter dirLimiter, batchSize int, fn filepath.WalkFunc) error {
+	info, err := stat()
+	if err != nil {
+		if err := fn(name, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+
+	err = walkPath(name, info, listing, limiter, batchSize, fn)
+	if err != nil && (!info.IsDir() || err != filepath.SkipDir) {
+		return err
+	}
+	return nil
+}
+
+// walkDirBatched visits a directory in the same order as walkPath, but lists
+// only names, batchSize at a time, and stats each entry just before visiting
+// it. On very wide directories this holds one short string per entry rather
+// than a FileInfo for every entry at once.
+func walkDirBatched(path string, info os.FileInfo, limiter dirLimiter, batchSize int, fn filepath.WalkFunc) error {
+	names, err := limiter.readDirNames(path, batchSize)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, name := range names {
+		name = filepath.Join(path, name)
+		stat := func() (os.FileInfo, error) { return os.Lstat(name) }
+		if err := walkEntry(name, stat, nil, limiter, batchSize, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// walkOrder returns the paths walkTree visits under root
+func walkOrder(t *testing.T, root string, maxOpenDirs, batchSize int) []string {
+	t.Helper()
+	var paths []string
+	err := walkTree(root, newDirLimiter(maxOpenDirs), batchSize, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+// TestWalkTreeOrder checks that neither reading directories ahead nor
+// listing them in batches changes the order of the walk
+func TestWalkTreeOrder(t *testing.T) {
+	root := writeTestTree(t)
+
+	var want []string
+	if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		want = append(want, path)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, maxOpenDirs := range []int{1, 4, 64} {
+		for _, batchSize := range []int{0, 1, 3, 1000} {
+			if got := walkOrder(t, root, maxOpenDirs, batchSize); !slices.Equal(got, want) {
+				t.Errorf("walkTree with %d open dirs and batch size %d visited\n%v\nwant filepath.Walk order\n%v",
+					maxOpenDirs, batchSize, got, want)
+			}
+		}
+	}
+}
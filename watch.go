@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long -watch waits after a change for more before
+// regenerating, so a save touching several files causes one run
+const watchDebounce = 300 * time.Millisecond
+
+// watcher keeps the output of -watch up to date. When a change only edits
+// files already in the output, and no option ties one file's section to
+// another's (see sectionConflict), the sections of those files are rebuilt
+// in this process and spliced into the output using the section index the
+// last full run wrote. Anything else, such as a file being added or
+// removed, an ignore file changing or a patch failing, runs this program
+// again with the same flags minus -watch, so a failing run cannot end the
+// watch. The header, including its generation time, is only rewritten by
+// full runs. Runs share a -cache-dir, a temporary one unless the user gave
+// their own, so unchanged files are stat'ed but not read or transformed
+// again.
+type watcher struct {
+	opts       *Options
+	args       []string
+	ignoreList *IgnoreList
+	fs         *fsnotify.Watcher
+	outputs    []string // absolute -output path, then the routed outputs
+	cacheDir   string   // absolute -cache-dir, skipped when in the tree
+	cache      *fileCache
+	indexPath  string // section index written by full runs, if patching is possible
+}
+
+// withoutFlags returns the command line arguments without the named boolean
+// flags
+func withoutFlags(args []string, names ...string) []string {
+	var kept []string
+	for _, arg := range args {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if strings.HasPrefix(arg, "-") && slices.Contains(names, name) {
+			continue
+		}
+		kept = append(kept, arg)
+	}
+	return kept
+}
+
+// watch regenerates the output once, then again after every change under
+// the scanned directory until interrupted
+func watch(opts *Options) error {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer notify.Close()
+
+	w := &watcher{
+		opts:       opts,
+		args:       withoutFlags(os.Args[1:], "watch"),
+		ignoreList: loadIgnoreLists(opts),
+		fs:         notify,
+	}
+	absOutput, _ := filepath.Abs(opts.outputPath)
+	w.outputs = append(w.outputs, absOutput)
+	for _, path := range opts.routes {
+		absRoute, _ := filepath.Abs(path)
+		w.outputs = append(w.outputs, absRoute)
+	}
+
+	dir, err := os.MkdirTemp("", "singlegen-watch-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	cacheDir := opts.cacheDir
+	switch {
+	case cacheDir != "":
+		w.cacheDir, _ = filepath.Abs(cacheDir)
+	case !opts.expandIncludes:
+		cacheDir = dir
+		w.args = append(w.args, "-cache-dir", cacheDir)
+	}
+
+	if conflict := sectionConflict(opts); conflict == "" {
+		w.indexPath = filepath.Join(dir, "sections.json")
+		w.args = append(w.args, "-section-index", w.indexPath)
+		if cacheDir != "" {
+			if w.cache, err = newFileCache(cacheDir, false); err != nil {
+				return err
+			}
+		}
+	} else if opts.verbose {
+		fmt.Printf("Every change regenerates the whole output because of %s\n", conflict)
+	}
+
+	if err := w.addTree(opts.dirPath); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// -clear-cache applies to the first run only; later ones rely on the
+	// cache it fills
+	w.regenerate()
+	w.args = withoutFlags(w.args, "clear-cache")
+	fmt.Printf("Watching %s for changes (Ctrl-C to stop)\n", opts.dirPath)
+
+	var pending []string
+	timer := time.NewTimer(0)
+	<-timer.C
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-notify.Events:
+			if !ok {
+				return nil
+			}
+			if !w.relevant(event) {
+				continue
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := w.addTree(event.Name); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+					}
+				}
+			}
+			// Ignore rules may have changed, so they are loaded afresh
+			if filepath.Base(event.Name) == ".gitignore" || filepath.Base(event.Name) == ".singlegenignore" {
+				if err := w.reloadIgnores(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				}
+			}
+			pending = append(pending, event.Name)
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-notify.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Warning: watch error: %v\n", err)
+
+		case <-timer.C:
+			if len(pending) == 0 {
+				continue
+			}
+			if w.opts.verbose {
+				for _, path := range pending {
+					fmt.Printf("Changed: %s\n", path)
+				}
+			}
+			if !w.patch(pending) {
+				fmt.Printf("Regenerating after %d changes\n", len(pending))
+				w.regenerate()
+			}
+			pending = pending[:0]
+		}
+	}
+}
+
+// addTree watches the directory at root and every directory below it that
+// is not ignored or excluded
+func (w *watcher) addTree(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && errors.Is(err, fs.ErrPermission) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != w.opts.dirPath {
+			prune, err := enterDir(path, w.ignoreList, nil, w.opts)
+			if prune {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+		if w.inCacheDir(path) {
+			return filepath.SkipDir
+		}
+		return w.fs.Add(path)
+	})
+}
+
+// reloadIgnores rebuilds the ignore rules after an ignore file changed and
+// watches any directories they no longer exclude. Directories they now
+// exclude stay watched; their changes are filtered out by relevant.
+func (w *watcher) reloadIgnores() error {
+	w.ignoreList = loadIgnoreLists(w.opts)
+	return w.addTree(w.opts.dirPath)
+}
+
+func (w *watcher) inCacheDir(path string) bool {
+	if w.cacheDir == "" {
+		return false
+	}
+	abs, _ := filepath.Abs(path)
+	return abs == w.cacheDir || strings.HasPrefix(abs, w.cacheDir+string(filepath.Separator))
+}
+
+// relevant reports whether an event can change the output. Writes of the
+// output itself, its chunk, shard and index siblings and the cache are not,
+// nor are changes to ignored paths.
+func (w *watcher) relevant(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+
+	abs, _ := filepath.Abs(event.Name)
+	if slices.Contains(w.outputs, abs) || isOutputSibling(w.outputs[0], abs) || w.inCacheDir(abs) {
+		return false
+	}
+
+	relPath, err := filepath.Rel(w.opts.dirPath, event.Name)
+	if err != nil {
+		return false
+	}
+	// Ignore files are never in the output but change what is
+	base := filepath.Base(relPath)
+	if base == ".gitignore" || base == ".singlegenignore" {
+		return true
+	}
+	if sub, subPath := findSubmodule(w.opts.submodules, relPath); sub != nil {
+		if sub.ignoreList.shouldIgnore(subPath) {
+			return false
+		}
+	} else if w.ignoreList.shouldIgnore(relPath) {
+		return false
+	}
+	return w.opts.excludes.match(relPath) < 0
+}
+
+// regenerate runs this program again without -watch. Failures are reported
+// and the watch goes on.
+func (w *watcher) regenerate() {
+	self, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error regenerating: %v\n", err)
+		return
+	}
+	cmd := exec.Command(self, w.args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error regenerating: %v\n", err)
+	}
+}
+
+// patch rewrites the sections of the changed files in place and reports
+// whether that accounted for every change. It declines, leaving the change
+// to a full run, whenever sections could be added, removed or reordered, or
+// a file's section cannot be rebuilt on its own.
+func (w *watcher) patch(changed []string) bool {
+	if w.indexPath == "" {
+		return false
+	}
+	index, err := loadSectionIndex(w.indexPath, w.opts.outputPath)
+	if err != nil {
+		return false
+	}
+
+	// Rebuilt sections keep the separators of the run that wrote the output
+	w.opts.separatorNonce = index.Nonce
+
+	var patches []sectionPatch
+	seen := make(map[int]bool)
+	for _, path := range changed {
+		base := filepath.Base(path)
+		if base == ".gitignore" || base == ".singlegenignore" {
+			return false
+		}
+
+		i := index.find(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			// A file that was not in the output and is gone again, such as
+			// an editor's temporary file, changes nothing
+			if i < 0 && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return false
+		}
+		if i < 0 || !info.Mode().IsRegular() {
+			return false
+		}
+		if seen[i] {
+			continue
+		}
+		seen[i] = true
+
+		content, hash, ok := w.rebuildSection(path, info, w.opts)
+		if !ok {
+			return false
+		}
+		if hash != index.Sections[i].Hash {
+			patches = append(patches, sectionPatch{i: i, content: content, hash: hash})
+		}
+	}
+
+	if len(patches) > 0 {
+		if err := patchSections(w.opts.outputPath, index, patches); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: patching %s: %v\n", w.opts.outputPath, err)
+			return false
+		}
+		// Without an up-to-date index the next change regenerates in full
+		if err := writeSectionIndex(w.indexPath, w.opts.outputPath, index); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: writing section index: %v\n", err)
+		}
+	}
+	fmt.Printf("Rewrote %d of %d sections in %s\n", len(patches), len(index.Sections), w.opts.outputPath)
+	return true
+}
+
+// rebuildSection processes one file as a run would and renders its section.
+// It fails for anything only a full run handles: an error, a file the run
+// would now skip, or findings a run has to report or fail on.
+func (w *watcher) rebuildSection(path string, info os.FileInfo, opts *Options) ([]byte, string, bool) {
+	relPath, err := filepath.Rel(opts.dirPath, path)
+	if err != nil {
+		return nil, "", false
+	}
+
+	entry, err := processWithTimeout(path, opts, func() (*FileEntry, error) {
+		if w.cache != nil {
+			return w.cache.process(path, relPath, info, opts)
+		}
+		return processFile(path, relPath, info, opts)
+	})
+	if err != nil || entry == nil {
+		return nil, "", false
+	}
+	if entry.release != nil {
+		defer entry.release()
+	}
+
+	if entry.skipReason != "" || len(entry.secrets) > 0 && (opts.reportSecrets || opts.failOnSecrets) ||
+		opts.validateUTF8 && firstInvalidUTF8(entry.content) >= 0 {
+		return nil, "", false
+	}
+	if sub, _ := findSubmodule(opts.submodules, relPath); sub != nil {
+		entry.submodule = sub.path
+	}
+	for _, warning := range entry.warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	content, hash, err := renderSection(entry, opts)
+	return content, hash, err == nil
+}